@@ -0,0 +1,260 @@
+package dbgsym
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"hermes/backend/elf"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BuildIDCopyWorkers bounds the binaries copied at the same time.
+const BuildIDCopyWorkers = 4
+
+// MappedFile is a file mapped by a process with the build ID of the mapped
+// file.
+type MappedFile struct {
+	Pid      uint32 `json:"pid"`
+	Filename string `json:"filename"`
+	BuildID  string `json:"build_id"`
+}
+
+type mappedFileKey struct {
+	pid      uint32
+	filename string
+}
+
+type inodeKey struct {
+	dev   uint64
+	ino   uint64
+	size  int64
+	mtime int64
+}
+
+// BuildIDCollector reads the build IDs of the files mapped by the processes
+// while they are collected. The mapped files are opened by
+// /proc/<pid>/map_files, or under /proc/<pid>/root, so that the files of the
+// containers and the ones replaced since being mapped are read instead of
+// the host paths. The binaries not found at their host paths are copied
+// into the output directory by their build IDs.
+type BuildIDCollector struct {
+	outputDir string
+	mutex     sync.Mutex
+	files     map[mappedFileKey]string
+	inodes    map[inodeKey]string
+	buildIDs  map[string]bool
+	workers   chan struct{}
+	waitGroup sync.WaitGroup
+}
+
+func NewBuildIDCollector(outputDir string) *BuildIDCollector {
+	return &BuildIDCollector{
+		outputDir: outputDir,
+		files:     map[mappedFileKey]string{},
+		inodes:    map[inodeKey]string{},
+		buildIDs:  map[string]bool{},
+		workers:   make(chan struct{}, BuildIDCopyWorkers),
+	}
+}
+
+// IsMappedFile returns whether the filename of a mapping is a file, the
+// pseudo files such as [vdso], [heap], //anon and the memfd files are not.
+func IsMappedFile(filename string) bool {
+	return strings.HasPrefix(filename, "/") && !strings.HasPrefix(filename, "//") &&
+		!strings.HasPrefix(filename, "/memfd:")
+}
+
+func (inst *BuildIDCollector) open(pid uint32, start, end uint64, filename string) (*os.File, error) {
+	paths := []string{
+		fmt.Sprintf("/proc/%d/map_files/%x-%x", pid, start, end),
+		filepath.Join("/proc", strconv.Itoa(int(pid)), "root", strings.TrimSuffix(filename, " (deleted)")),
+	}
+	var err error
+	for _, path := range paths {
+		var fp *os.File
+		if fp, err = os.Open(path); err == nil {
+			return fp, nil
+		}
+	}
+	return nil, err
+}
+
+// isHostFile returns whether the host path of the mapping is the mapped file.
+func (inst *BuildIDCollector) isHostFile(filename string, stat *syscall.Stat_t) bool {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return false
+	}
+	hostStat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && hostStat.Dev == stat.Dev && hostStat.Ino == stat.Ino
+}
+
+func (inst *BuildIDCollector) copyFile(fp *os.File, size int64, dstPath string) error {
+	if err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm); err != nil {
+		return err
+	}
+	tmpFp, err := os.CreateTemp(filepath.Dir(dstPath), filepath.Base(dstPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFp.Name())
+	_, err = io.Copy(tmpFp, io.NewSectionReader(fp, 0, size))
+	if _err := tmpFp.Close(); err == nil {
+		err = _err
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFp.Name(), dstPath)
+}
+
+// copy copies the opened file in the background, as the process may exit
+// or unmap the file before it is copied.
+func (inst *BuildIDCollector) copy(fp *os.File, size int64, buildID string) {
+	dstPath := NewBuildID(UserMode, "", inst.outputDir).GetUserBinaryPath(buildID)
+	if _, err := os.Stat(dstPath); err == nil {
+		fp.Close()
+		return
+	}
+
+	inst.waitGroup.Add(1)
+	go func() {
+		defer inst.waitGroup.Done()
+		defer fp.Close()
+		inst.workers <- struct{}{}
+		defer func() { <-inst.workers }()
+
+		if err := inst.copyFile(fp, size, dstPath); err != nil {
+			logrus.Errorf("Failed to copy binary of build ID [%s], err [%s]", buildID, err)
+		}
+	}()
+}
+
+// getBuildID reads the build ID of the opened file, the files are read once
+// by inode and the ones mapped by the other processes are cached.
+func (inst *BuildIDCollector) getBuildID(fp *os.File, filename string) string {
+	info, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return ""
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		fp.Close()
+		return ""
+	}
+	key := inodeKey{
+		dev:   uint64(stat.Dev),
+		ino:   stat.Ino,
+		size:  info.Size(),
+		mtime: info.ModTime().UnixNano(),
+	}
+	inst.mutex.Lock()
+	buildID, isExist := inst.inodes[key]
+	inst.mutex.Unlock()
+	if isExist {
+		fp.Close()
+		return buildID
+	}
+
+	buildID, err = elf.NewGetBuildID().Reader(fp)
+	if err != nil {
+		buildID = ""
+	}
+	inst.mutex.Lock()
+	inst.inodes[key] = buildID
+	isNew := buildID != "" && !inst.buildIDs[buildID]
+	if isNew {
+		inst.buildIDs[buildID] = true
+	}
+	inst.mutex.Unlock()
+
+	if isNew && !inst.isHostFile(filename, stat) {
+		inst.copy(fp, info.Size(), buildID)
+	} else {
+		fp.Close()
+	}
+	return buildID
+}
+
+// Add reads the build ID of the file mapped by the process at the address
+// range, a file is read once per process.
+func (inst *BuildIDCollector) Add(pid uint32, start, end uint64, filename string) {
+	if !IsMappedFile(filename) {
+		return
+	}
+	key := mappedFileKey{pid: pid, filename: filename}
+	inst.mutex.Lock()
+	_, isExist := inst.files[key]
+	inst.mutex.Unlock()
+	if isExist {
+		return
+	}
+
+	buildID := ""
+	if fp, err := inst.open(pid, start, end, filename); err == nil {
+		buildID = inst.getBuildID(fp, filename)
+	}
+	inst.mutex.Lock()
+	inst.files[key] = buildID
+	inst.mutex.Unlock()
+}
+
+// Close waits for the binaries being copied.
+func (inst *BuildIDCollector) Close() {
+	inst.waitGroup.Wait()
+}
+
+func (inst *BuildIDCollector) GetMappedFiles() []MappedFile {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	files := make([]MappedFile, 0, len(inst.files))
+	for key, buildID := range inst.files {
+		if buildID == "" {
+			continue
+		}
+		files = append(files, MappedFile{
+			Pid:      key.pid,
+			Filename: key.filename,
+			BuildID:  buildID,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Pid != files[j].Pid {
+			return files[i].Pid < files[j].Pid
+		}
+		return files[i].Filename < files[j].Filename
+	})
+	return files
+}
+
+func (inst *BuildIDCollector) WriteToFile(path string) error {
+	bytes, err := json.Marshal(inst.GetMappedFiles())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bytes, 0644)
+}
+
+// LoadMappedFiles reads back the mapped files of WriteToFile.
+func LoadMappedFiles(path string) ([]MappedFile, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []MappedFile
+	if err := json.Unmarshal(bytes, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
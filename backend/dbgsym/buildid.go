@@ -1,14 +1,27 @@
 package dbgsym
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"hermes/backend/elf"
 	"hermes/common"
 )
 
+// KernelBuildIDPostfix is appended to the path of a kallsyms snapshot for
+// the file of the build ID of the kernel.
+const KernelBuildIDPostfix = ".build_id"
+
+// KernelSymIDSep separates the build ID of the kernel and the digest of the
+// kallsyms in the names of the kernel directories.
+const KernelSymIDSep = "."
+
 type CpuMode int
 
 const (
@@ -44,19 +57,62 @@ func (inst *BuildID) GetUserPath(buildID string) string {
 	return inst.composePath(buildID, "debuginfo")
 }
 
-func (inst *BuildID) buildKernel() (string, error) {
-	buildID, err := inst.getBuildID.Kernel()
+func (inst *BuildID) GetUserBinaryPath(buildID string) string {
+	return inst.composePath(buildID, "binary")
+}
+
+func getDigest(filePath string) (string, error) {
+	fp, err := os.Open(filePath)
 	if err != nil {
 		return "", err
 	}
+	defer fp.Close()
 
-	srcPath := "/proc/kallsyms"
-	dstPath := inst.GetKernelPath(buildID)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, fp); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// ImportKernel copies a kallsyms of the kernel of the build ID into the output
+// directory and returns the ID of its directory. The kallsyms are named by
+// the digest as well, as the addresses of the modules and of KASLR change
+// without a new build ID, so that a file is copied once and never changes.
+func (inst *BuildID) ImportKernel(buildID, srcPath string) (string, error) {
+	digest, err := getDigest(srcPath)
+	if err != nil {
+		return "", err
+	}
+	symID := buildID + KernelSymIDSep + digest
+	dstPath := inst.GetKernelPath(symID)
 	if _, err := os.Stat(dstPath); err == nil {
-		return dstPath, err
+		return symID, nil
 	}
 
-	return buildID, common.CopyFile(srcPath, dstPath)
+	if err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm); err != nil {
+		return "", err
+	}
+	// the loaders only see the file once it's complete
+	fp, err := os.CreateTemp(filepath.Dir(dstPath), filepath.Base(dstPath)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := fp.Name()
+	fp.Close()
+	defer os.Remove(tmpPath)
+	if err := common.CopyFile(srcPath, tmpPath); err != nil {
+		return "", err
+	}
+	return symID, os.Rename(tmpPath, dstPath)
+}
+
+func (inst *BuildID) buildKernel() (string, error) {
+	buildID, err := inst.getBuildID.Kernel()
+	if err != nil {
+		return "", err
+	}
+	return inst.ImportKernel(buildID, "/proc/kallsyms")
 }
 
 func (inst *BuildID) buildUser() (string, error) {
@@ -79,6 +135,42 @@ func (inst *BuildID) Build() (string, error) {
 	return "", fmt.Errorf("Unhandled mode: %d", inst.mode)
 }
 
+// SnapshotKernel copies /proc/kallsyms into the data of a run along with the
+// build ID of the kernel. Each run keeps its own snapshot, as KASLR and the
+// addresses of the modules change without a new build ID.
+func SnapshotKernel(kernSymPath string) (string, error) {
+	buildID, err := elf.NewGetBuildID().Kernel()
+	if err != nil {
+		return "", err
+	}
+	if err := common.CopyFile("/proc/kallsyms", kernSymPath); err != nil {
+		return "", err
+	}
+	return buildID, ioutil.WriteFile(kernSymPath+KernelBuildIDPostfix, []byte(buildID), 0644)
+}
+
+// GetKernelBuildID returns the build ID of a kallsyms snapshot of a run, the
+// snapshots of the older runs are symlinks into the directory of the build ID.
+func GetKernelBuildID(kernSymPath string) (string, error) {
+	if bytes, err := ioutil.ReadFile(kernSymPath + KernelBuildIDPostfix); err == nil {
+		return strings.TrimSpace(string(bytes)), nil
+	}
+	path, err := filepath.EvalSymlinks(kernSymPath)
+	if err != nil {
+		return "", err
+	}
+	if path == kernSymPath {
+		return "", fmt.Errorf("Failed to find build ID of [%s]", kernSymPath)
+	}
+	return GetBuildIDByPath(path), nil
+}
+
+// GetBuildIDBySymID returns the build ID of the kernel of an ID of
+// ImportKernel.
+func GetBuildIDBySymID(symID string) string {
+	return strings.SplitN(symID, KernelSymIDSep, 2)[0]
+}
+
 func GetBuildIDByPath(path string) string {
 	return filepath.Base(filepath.Dir(path))
 }
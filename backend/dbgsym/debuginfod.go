@@ -1,10 +1,22 @@
 package dbgsym
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hermes/backend/elf"
+	"hermes/common"
 )
 
 const (
@@ -13,42 +25,257 @@ const (
 	DebugInfo     = "debuginfo"
 )
 
+// The environment variables of the debuginfod clients, DEBUGINFOD_URLS is a
+// space separated list of the servers, none if it is empty, and
+// DEBUGINFOD_TIMEOUT is in seconds.
+const (
+	DebugInfodURLsEnv    = "DEBUGINFOD_URLS"
+	DebugInfodTimeoutEnv = "DEBUGINFOD_TIMEOUT"
+)
+
+const (
+	DebugInfodTimeout = 90 * time.Second
+	DebugInfodRetries = 3
+	DebugInfodBackoff = time.Second
+	// DebugInfodUnreachableTTL is how long a server is skipped once it has
+	// failed with network errors
+	DebugInfodUnreachableTTL = 10 * time.Minute
+)
+
+// ErrNoServers is returned when DEBUGINFOD_URLS disables the servers.
+var ErrNoServers = errors.New("No debuginfod servers")
+
+// unreachableServers are the servers that have failed with network errors
+// by the time they failed, so that the downloads of the other build IDs
+// don't wait for the retries on an offline host.
+var unreachableServers sync.Map
+
+// DebugInfod downloads the debuginfo of a build ID from the servers in
+// order. A server can be a local directory, or a file:// URL, mirroring the
+// buildid/<build ID>/debuginfo layout or the .build-id/xx/yyyy.debug layout
+// of the debug packages for the air-gapped hosts.
 type DebugInfod struct {
-	url     string
+	urls    []string
 	dstPath string
+	client  *http.Client
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+}
+
+// newTransport bounds the connection and the response headers by the
+// timeout, the debuginfo can take longer to download as long as the body
+// keeps coming, see idleReader.
+func newTransport(timeout time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	return transport
 }
 
 func NewDebugInfod(dstPath string) *DebugInfod {
+	urls := []string{DebugInfodURL}
+	if env, isExist := os.LookupEnv(DebugInfodURLsEnv); isExist {
+		urls = strings.Fields(env)
+	}
+	timeout := DebugInfodTimeout
+	if seconds, err := strconv.Atoi(os.Getenv(DebugInfodTimeoutEnv)); err == nil && seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+
 	return &DebugInfod{
-		url:     DebugInfodURL,
+		urls:    urls,
 		dstPath: dstPath,
+		client:  &http.Client{Transport: newTransport(timeout)},
+		timeout: timeout,
+		retries: DebugInfodRetries,
+		backoff: DebugInfodBackoff,
 	}
 }
 
-func (inst *DebugInfod) DownloadDebugInfo(buildID string) error {
-	if _, err := os.Stat(inst.dstPath); err == nil {
-		return nil
+// SetURLs overrides the servers of DEBUGINFOD_URLS.
+func (inst *DebugInfod) SetURLs(urls []string) {
+	inst.urls = urls
+}
+
+// SetTimeout overrides the timeout of the connection, of the response
+// headers and of the idle body of DEBUGINFOD_TIMEOUT.
+func (inst *DebugInfod) SetTimeout(timeout time.Duration) {
+	inst.client.Transport = newTransport(timeout)
+	inst.timeout = timeout
+}
+
+func (inst *DebugInfod) SetRetries(retries int, backoff time.Duration) {
+	inst.retries = retries
+	inst.backoff = backoff
+}
+
+// getLocalDir returns the directory of a local mirror, or "" for a server.
+func (inst *DebugInfod) getLocalDir(server string) string {
+	if strings.HasPrefix(server, "/") {
+		return server
 	}
+	if _url, err := url.Parse(server); err == nil && _url.Scheme == "file" {
+		return _url.Path
+	}
+	return ""
+}
 
-	if err := os.MkdirAll(filepath.Dir(inst.dstPath), os.ModePerm); err != nil {
-		return err
+func (inst *DebugInfod) copyLocal(dir, buildID, tmpPath string) error {
+	paths := []string{filepath.Join(dir, Buildid, buildID, DebugInfo)}
+	if len(buildID) > 2 {
+		paths = append(paths, filepath.Join(dir, ".build-id", buildID[:2], buildID[2:]+".debug"))
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return common.CopyFile(path, tmpPath)
+		}
 	}
-	url, err := url.JoinPath(inst.url, Buildid, buildID, DebugInfo)
+	return fmt.Errorf("Failed to find debuginfo of build ID [%s] in [%s]", buildID, dir)
+}
+
+// statusError is a response of the server other than 200.
+type statusError struct {
+	url        string
+	statusCode int
+}
+
+func (err *statusError) Error() string {
+	return fmt.Sprintf("Unexpected status [%d] of [%s]", err.statusCode, err.url)
+}
+
+// idleReader extends the deadline of the request whenever the body is read,
+// so that a server stalled in the middle of the body is given up.
+type idleReader struct {
+	reader  io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (inst *idleReader) Read(buf []byte) (int, error) {
+	n, err := inst.reader.Read(buf)
+	inst.timer.Reset(inst.timeout)
+	return n, err
+}
+
+func (inst *DebugInfod) get(_url, tmpPath string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	timer := time.AfterFunc(inst.timeout, cancel)
+	defer timer.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, _url, nil)
 	if err != nil {
 		return err
 	}
-
-	resp, err := http.Get(url)
+	resp, err := inst.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	fp, err := os.Create(inst.dstPath)
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{url: _url, statusCode: resp.StatusCode}
+	}
+
+	fp, err := os.Create(tmpPath)
 	if err != nil {
 		return err
 	}
 	defer fp.Close()
-	fp.ReadFrom(resp.Body)
+	_, err = io.Copy(fp, &idleReader{reader: resp.Body, timer: timer, timeout: inst.timeout})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("Failed to read [%s], no data for [%s]", _url, inst.timeout)
+	}
+	return err
+}
+
+// download retries the network errors and the server errors, the other
+// status codes such as 404 are returned at once.
+func (inst *DebugInfod) download(server, buildID, tmpPath string) error {
+	_url, err := url.JoinPath(server, Buildid, buildID, DebugInfo)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		err = inst.get(_url, tmpPath)
+		if err == nil {
+			return nil
+		}
+		if _err, ok := err.(*statusError); ok && _err.statusCode < http.StatusInternalServerError {
+			return err
+		}
+		if i >= inst.retries {
+			if _, ok := err.(*statusError); !ok {
+				unreachableServers.Store(server, time.Now())
+			}
+			return err
+		}
+		time.Sleep(inst.backoff * time.Duration(i+1))
+	}
+}
+
+func (inst *DebugInfod) validate(buildID, filePath string) error {
+	_buildID, err := elf.NewGetBuildID().File(filePath)
+	if err != nil {
+		return err
+	}
+	if _buildID != buildID {
+		return fmt.Errorf("Unexpected build ID [%s], expected [%s]", _buildID, buildID)
+	}
 	return nil
 }
+
+func (inst *DebugInfod) fetch(server, buildID, tmpPath string) error {
+	var err error
+	if dir := inst.getLocalDir(server); dir != "" {
+		err = inst.copyLocal(dir, buildID, tmpPath)
+	} else {
+		err = inst.download(server, buildID, tmpPath)
+	}
+	if err != nil {
+		return err
+	}
+	return inst.validate(buildID, tmpPath)
+}
+
+func (inst *DebugInfod) DownloadDebugInfo(buildID string) error {
+	if _, err := os.Stat(inst.dstPath); err == nil {
+		return nil
+	}
+
+	if len(inst.urls) == 0 {
+		return ErrNoServers
+	}
+	if err := os.MkdirAll(filepath.Dir(inst.dstPath), os.ModePerm); err != nil {
+		return err
+	}
+	// the debuginfo is only seen once it's complete and validated, the
+	// temporary file is not shared with the other downloads of the build ID
+	tmpFp, err := os.CreateTemp(filepath.Dir(inst.dstPath), filepath.Base(inst.dstPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFp.Name()
+	tmpFp.Close()
+	defer os.Remove(tmpPath)
+
+	errs := []string{}
+	for _, server := range inst.urls {
+		if failedAt, isExist := unreachableServers.Load(server); isExist && time.Since(failedAt.(time.Time)) < DebugInfodUnreachableTTL {
+			errs = append(errs, fmt.Sprintf("Server [%s] is unreachable", server))
+			continue
+		}
+		err := inst.fetch(server, buildID, tmpPath)
+		if err == nil {
+			return os.Rename(tmpPath, inst.dstPath)
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("Failed to download debuginfo of build ID [%s], err [%s]", buildID, strings.Join(errs, "; "))
+}
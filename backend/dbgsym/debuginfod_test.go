@@ -0,0 +1,218 @@
+package dbgsym
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hermes/backend/elf"
+	"hermes/common"
+)
+
+// getTestElf returns the test binary and its build ID as the debuginfo to
+// serve.
+func getTestElf(t *testing.T) (string, string) {
+	filePath, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildID, err := elf.NewGetBuildID().File(filePath)
+	if err != nil || buildID == "" {
+		t.Skipf("The build ID of [%s] doesn't exist, err [%v]", filePath, err)
+	}
+	return filePath, buildID
+}
+
+func newTestDebugInfod(t *testing.T, urls ...string) (*DebugInfod, string) {
+	dstPath := filepath.Join(t.TempDir(), "debuginfo")
+	debugInfod := NewDebugInfod(dstPath)
+	debugInfod.SetURLs(urls)
+	debugInfod.SetRetries(2, time.Millisecond)
+	return debugInfod, dstPath
+}
+
+func serveFile(filePath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filePath)
+	}
+}
+
+func TestDebugInfodURLsEnv(t *testing.T) {
+	t.Setenv(DebugInfodURLsEnv, "https://a.example  file:///srv/debug\thttps://b.example")
+	t.Setenv(DebugInfodTimeoutEnv, "5")
+	debugInfod := NewDebugInfod("")
+	if strings.Join(debugInfod.urls, ",") != "https://a.example,file:///srv/debug,https://b.example" {
+		t.Fatalf("Unexpected urls [%v]", debugInfod.urls)
+	}
+	transport := debugInfod.client.Transport.(*http.Transport)
+	if debugInfod.client.Timeout != 0 || transport.ResponseHeaderTimeout != 5*time.Second {
+		t.Fatalf("Unexpected timeout [%s], response header timeout [%s]", debugInfod.client.Timeout, transport.ResponseHeaderTimeout)
+	}
+
+	t.Setenv(DebugInfodURLsEnv, "")
+	if debugInfod := NewDebugInfod(""); len(debugInfod.urls) != 0 {
+		t.Fatalf("Unexpected urls [%v] of empty env", debugInfod.urls)
+	}
+
+	os.Unsetenv(DebugInfodURLsEnv)
+	if debugInfod := NewDebugInfod(""); len(debugInfod.urls) != 1 || debugInfod.urls[0] != DebugInfodURL {
+		t.Fatalf("Unexpected default urls [%v]", debugInfod.urls)
+	}
+}
+
+func TestDebugInfodFallback(t *testing.T) {
+	filePath, buildID := getTestElf(t)
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		serveFile(filePath)(w, r)
+	}))
+	defer server.Close()
+
+	debugInfod, dstPath := newTestDebugInfod(t, notFound.URL, server.URL)
+	if err := debugInfod.DownloadDebugInfo(buildID); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/buildid/"+buildID+"/debuginfo" {
+		t.Fatalf("Unexpected path [%s]", path)
+	}
+	if _buildID, err := elf.NewGetBuildID().File(dstPath); err != nil || _buildID != buildID {
+		t.Fatalf("Unexpected build ID [%s], err [%v]", _buildID, err)
+	}
+}
+
+func TestDebugInfodNotFound(t *testing.T) {
+	_, buildID := getTestElf(t)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	debugInfod, dstPath := newTestDebugInfod(t, server.URL)
+	if err := debugInfod.DownloadDebugInfo(buildID); err == nil {
+		t.Fatal("The download of a 404 succeeded")
+	}
+	if _, err := os.Stat(dstPath); err == nil {
+		t.Fatal("The body of a 404 is saved")
+	}
+	if requests != 1 {
+		t.Fatalf("Unexpected requests [%d] of a 404", requests)
+	}
+}
+
+func TestDebugInfodRetry(t *testing.T) {
+	filePath, buildID := getTestElf(t)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		serveFile(filePath)(w, r)
+	}))
+	defer server.Close()
+
+	debugInfod, _ := newTestDebugInfod(t, server.URL)
+	if err := debugInfod.DownloadDebugInfo(buildID); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Fatalf("Unexpected requests [%d]", requests)
+	}
+}
+
+func TestDebugInfodTimeout(t *testing.T) {
+	_, buildID := getTestElf(t)
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	debugInfod, _ := newTestDebugInfod(t, server.URL)
+	debugInfod.SetTimeout(50 * time.Millisecond)
+	debugInfod.SetRetries(0, 0)
+	if err := debugInfod.DownloadDebugInfo(buildID); err == nil {
+		t.Fatal("The download didn't time out")
+	}
+}
+
+// TestDebugInfodSlowBody checks that a server stalled in the middle of the
+// body is given up after the timeout.
+func TestDebugInfodSlowBody(t *testing.T) {
+	filePath, buildID := getTestElf(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fp, err := os.Open(filePath)
+		if err != nil {
+			return
+		}
+		defer fp.Close()
+		w.WriteHeader(http.StatusOK)
+		io.CopyN(w, fp, 64)
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		io.Copy(w, fp)
+	}))
+	defer server.Close()
+
+	debugInfod, dstPath := newTestDebugInfod(t, server.URL)
+	debugInfod.SetTimeout(50 * time.Millisecond)
+	debugInfod.SetRetries(0, 0)
+	if err := debugInfod.DownloadDebugInfo(buildID); err == nil {
+		t.Fatal("The stalled download doesn't time out")
+	}
+	if _, err := os.Stat(dstPath); err == nil {
+		t.Fatal("The stalled download is saved")
+	}
+}
+
+func TestDebugInfodBuildIDMismatch(t *testing.T) {
+	filePath, _ := getTestElf(t)
+	server := httptest.NewServer(serveFile(filePath))
+	defer server.Close()
+
+	buildID := strings.Repeat("ab", 20)
+	debugInfod, dstPath := newTestDebugInfod(t, server.URL)
+	if err := debugInfod.DownloadDebugInfo(buildID); err == nil {
+		t.Fatal("The debuginfo of another build ID is accepted")
+	}
+	if _, err := os.Stat(dstPath); err == nil {
+		t.Fatal("The debuginfo of another build ID is saved")
+	}
+}
+
+func TestDebugInfodLocalMirror(t *testing.T) {
+	filePath, buildID := getTestElf(t)
+	mirrorDir := t.TempDir()
+	if err := common.CopyFile(filePath, filepath.Join(mirrorDir, Buildid, buildID, DebugInfo)); err != nil {
+		t.Fatal(err)
+	}
+	debugDir := t.TempDir()
+	if err := common.CopyFile(filePath, filepath.Join(debugDir, ".build-id", buildID[:2], buildID[2:]+".debug")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range []string{mirrorDir, "file://" + debugDir} {
+		debugInfod, dstPath := newTestDebugInfod(t, url)
+		if err := debugInfod.DownloadDebugInfo(buildID); err != nil {
+			t.Fatalf("Failed to copy from [%s], err [%s]", url, err)
+		}
+		if _, err := os.Stat(dstPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
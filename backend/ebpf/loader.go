@@ -8,11 +8,13 @@ import (
 
 	iolat "hermes/backend/ebpf/io_latency"
 	memory "hermes/backend/ebpf/memory_alloc"
+	offcpu "hermes/backend/ebpf/offcpu"
 )
 
 const (
 	MemoryEbpf = "memory"
 	IoLatEbpf  = "io_latency"
+	OffCpuEbpf = "offcpu"
 )
 
 type Loader interface {
@@ -29,6 +31,8 @@ func GetLoader(ebpfType string) (Loader, error) {
 		return memory.GetLoader()
 	case IoLatEbpf:
 		return iolat.GetLoader()
+	case OffCpuEbpf:
+		return offcpu.GetLoader()
 	}
 	return nil, fmt.Errorf("Unahndled ebpf type [%s]", ebpfType)
 }
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"os"
-	"path/filepath"
 
 	"hermes/backend/dbgsym"
 	"hermes/backend/utils"
@@ -44,19 +43,8 @@ func (loader *MemoryLoader) GetLogDataPathPostfix() string {
 }
 
 func (loader *MemoryLoader) Prepare(logPathManager log.LogPathManager) error {
-	buildID := dbgsym.NewBuildID(dbgsym.KernelMode, "", logPathManager.DbgsymPath())
-	if _buildID, err := buildID.Build(); err != nil {
-		return err
-	} else {
-		kernSymPath := logPathManager.DataPath(KernSymFilePostfix)
-		dbgKernelPath := buildID.GetKernelPath(_buildID)
-		if relPath, err := filepath.Rel(filepath.Dir(kernSymPath), dbgKernelPath); err != nil {
-			logrus.Errorf("Failed to get a relative path of [%s], [%s], err [%s]", kernSymPath, dbgKernelPath, err)
-		} else if err := os.Symlink(relPath, kernSymPath); err != nil {
-			logrus.Errorf("Failed to create a symlink [%s], target [%s], err [%s]", kernSymPath, relPath, err)
-		}
-	}
-	return nil
+	_, err := dbgsym.SnapshotKernel(logPathManager.DataPath(KernSymFilePostfix))
+	return err
 }
 
 func (loader *MemoryLoader) Load(ctx context.Context) error {
@@ -0,0 +1,183 @@
+package ebpf
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"hermes/backend/dbgsym"
+	"hermes/backend/perf"
+	"hermes/log"
+
+	"github.com/sirupsen/logrus"
+	ebpfUtils "hermes/backend/ebpf/utils"
+)
+
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -target $BPF_ARCH -cc $BPF_CLANG -cflags $BPF_CFLAGS bpf offcpu.c -- -I$BPF_VMLINUX_HEADER
+const (
+	OffCpuRecFilePostfix   = ".offcpu.rec"
+	KernSymFilePostfix     = ".offcpu.kern.sym"
+	SynthEventsFilePostfix = ".offcpu.synth_events"
+	BuildIDsFilePostfix    = ".offcpu.build_ids"
+)
+
+const CallStackSize = 127
+
+type OffCpuLoader struct {
+	objs *bpfObjects
+}
+
+func GetLoader() (*OffCpuLoader, error) {
+	// Load pre-compiled programs and maps into the kernel.
+	objs := bpfObjects{}
+	if err := loadBpfObjects(&objs, nil); err != nil {
+		return nil, err
+	}
+
+	return &OffCpuLoader{
+		objs: &objs,
+	}, nil
+}
+
+func (loader *OffCpuLoader) GetLogDataPathPostfix() string {
+	return ".offcpu.*"
+}
+
+func (loader *OffCpuLoader) Prepare(logPathManager log.LogPathManager) error {
+	_, err := dbgsym.SnapshotKernel(logPathManager.DataPath(KernSymFilePostfix))
+	return err
+}
+
+func (loader *OffCpuLoader) Load(ctx context.Context) error {
+	tpSchedSwitch, err := ebpfUtils.Tracepoint("sched", "sched_switch", loader.objs.SchedSwitch)
+	if err != nil {
+		logrus.Errorf("Failed to open sched_switch tracepoint, err [%s]", err)
+		return err
+	}
+	defer ebpfUtils.Close(tpSchedSwitch)
+
+	<-ctx.Done()
+	return nil
+}
+
+func uint8ToString(val []uint8) string {
+	bytes := []byte{}
+	for _, _byte := range val {
+		if _byte == 0 {
+			break
+		}
+		bytes = append(bytes, byte(_byte))
+	}
+
+	if len(bytes) == 0 {
+		return string("unknown")
+	}
+	return string(bytes)
+}
+
+// OffCpuRecord is the time a task spent off-CPU, in microseconds, blocked
+// at the same kernel and user stacks. The call chains start from the leaf.
+type OffCpuRecord struct {
+	Comm          string   `json:"comm"`
+	Pid           uint32   `json:"pid"`
+	Tgid          uint32   `json:"tgid"`
+	OffCpuUs      uint64   `json:"offcpu_us"`
+	KernCallchain []uint64 `json:"kern_callchain"`
+	UserCallchain []uint64 `json:"user_callchain"`
+}
+
+func (loader *OffCpuLoader) getCallchain(stackID int32) []uint64 {
+	callchain := []uint64{}
+	// a negative id means the stack could not be collected
+	if stackID < 0 {
+		return callchain
+	}
+
+	ips := make([]uint64, CallStackSize)
+	if err := loader.objs.StackTrace.Lookup(uint32(stackID), &ips); err != nil {
+		return callchain
+	}
+	for _, ip := range ips {
+		if ip == 0 {
+			break
+		}
+		callchain = append(callchain, ip)
+	}
+	return callchain
+}
+
+func (loader *OffCpuLoader) getOffCpuRecs() []OffCpuRecord {
+	var key bpfOffCpuKey
+	var offCpuUs uint64
+	recs := []OffCpuRecord{}
+
+	iter := loader.objs.OffCpu.Iterate()
+	for iter.Next(&key, &offCpuUs) {
+		recs = append(recs, OffCpuRecord{
+			Comm:          uint8ToString(key.Comm[:]),
+			Pid:           key.Pid,
+			Tgid:          key.Tgid,
+			OffCpuUs:      offCpuUs,
+			KernCallchain: loader.getCallchain(key.KernStackId),
+			UserCallchain: loader.getCallchain(key.UserStackId),
+		})
+	}
+	if err := iter.Err(); err != nil {
+		logrus.Errorf("Failed to iterate off-cpu map, err [%s]", err)
+	}
+	return recs
+}
+
+// storeMaps synthesizes the maps of the processes with user call chains and
+// reads the build IDs of the mapped files, the ones of the processes that
+// have exited are left unsymbolized.
+func (loader *OffCpuLoader) storeMaps(recs []OffCpuRecord, logPathManager log.LogPathManager) error {
+	tgids := []int32{}
+	isAdded := map[uint32]bool{}
+	for i := range recs {
+		if len(recs[i].UserCallchain) != 0 && !isAdded[recs[i].Tgid] {
+			isAdded[recs[i].Tgid] = true
+			tgids = append(tgids, int32(recs[i].Tgid))
+		}
+	}
+
+	buildIDs := dbgsym.NewBuildIDCollector(logPathManager.DbgsymPath())
+	synthesizeEvents, err := perf.NewSynthesizeEvents(logPathManager.DataPath(SynthEventsFilePostfix), perf.WriterOptions{})
+	if err != nil {
+		return err
+	}
+	synthesizeEvents.SetBuildIDCollector(buildIDs)
+	synthesizeEvents.SynthesizePids(tgids)
+	if err := synthesizeEvents.Close(); err != nil {
+		return err
+	}
+	buildIDs.Close()
+	return buildIDs.WriteToFile(logPathManager.DataPath(BuildIDsFilePostfix))
+}
+
+func (loader *OffCpuLoader) StoreData(logPathManager log.LogPathManager) error {
+	recs := loader.getOffCpuRecs()
+	if err := loader.storeMaps(recs, logPathManager); err != nil {
+		logrus.Errorf("Failed to store maps of off-cpu processes, err [%s]", err)
+	}
+	bytes, err := json.Marshal(recs)
+	if err != nil {
+		return err
+	}
+
+	fp, err := os.OpenFile(logPathManager.DataPath(OffCpuRecFilePostfix), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		logrus.Errorf("Failed to write off-cpu records to file, err [%s]", err)
+		return err
+	}
+	defer fp.Close()
+
+	if _, err = fp.Write(bytes); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (loader *OffCpuLoader) Close() {
+	loader.objs.Close()
+}
@@ -0,0 +1,122 @@
+// +build ignore
+
+#include "vmlinux.h"
+
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+char __license[] SEC("license") = "Dual MIT/GPL";
+
+#define MAX_ENTRIES 10240
+#define MAX_STACK_ENTRIES 16384
+#define PERF_MAX_STACK_DEPTH 127
+#define TASK_COMM_LEN 16
+/* the prev_state of the preempted tasks, the states below it are the
+ * blocked ones and TASK_RUNNING is zero */
+#define TASK_REPORT_MAX 0x100
+
+struct OffCpuKey {
+  u32 pid;
+  u32 tgid;
+  s32 kern_stack_id;
+  s32 user_stack_id;
+  unsigned char comm[TASK_COMM_LEN];
+};
+
+struct OffCpuStart {
+  u64 ts;
+  u32 tgid;
+  s32 kern_stack_id;
+  s32 user_stack_id;
+};
+
+struct {
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __type(key, u32);
+  __type(value, struct OffCpuStart);
+  __uint(max_entries, MAX_ENTRIES);
+} starts SEC(".maps");
+
+/* microseconds off-CPU per task and stacks */
+struct {
+  __uint(type, BPF_MAP_TYPE_HASH);
+  __type(key, struct OffCpuKey);
+  __type(value, u64);
+  __uint(max_entries, MAX_ENTRIES);
+} off_cpu SEC(".maps");
+
+struct {
+  __uint(type, BPF_MAP_TYPE_STACK_TRACE);
+  __uint(key_size, sizeof(u32));
+  __uint(value_size, PERF_MAX_STACK_DEPTH * sizeof(u64));
+  __uint(max_entries, MAX_STACK_ENTRIES);
+} stack_trace SEC(".maps");
+
+/* from /sys/kernel/debug/tracing/events/sched/sched_switch/format */
+struct SchedSwitchInfo {
+  u16 common_type;
+  u8 common_flags;
+  u8 common_preempt_count;
+  int common_pid;
+  char prev_comm[TASK_COMM_LEN];
+  int prev_pid;
+  int prev_prio;
+  long prev_state;
+  char next_comm[TASK_COMM_LEN];
+  int next_pid;
+  int next_prio;
+};
+
+SEC("tracepoint/sched/sched_switch")
+int sched_switch(struct SchedSwitchInfo *ctx) {
+  u32 prev_pid = ctx->prev_pid;
+  u32 next_pid = ctx->next_pid;
+  u64 ts = bpf_ktime_get_ns();
+
+  /* the previous task is still current, record where it blocks. The
+   * preempted tasks are still runnable and aren't off-CPU */
+  if (prev_pid != 0 && (ctx->prev_state & (TASK_REPORT_MAX - 1)) != 0) {
+    struct OffCpuStart start = {
+        .ts = ts,
+        .tgid = bpf_get_current_pid_tgid() >> 32,
+        .kern_stack_id = bpf_get_stackid(ctx, &stack_trace, 0),
+        .user_stack_id = bpf_get_stackid(ctx, &stack_trace, BPF_F_USER_STACK),
+    };
+    bpf_map_update_elem(&starts, &prev_pid, &start, BPF_ANY);
+  }
+
+  if (next_pid == 0) {
+    return 0;
+  }
+  struct OffCpuStart *start = bpf_map_lookup_elem(&starts, &next_pid);
+  if (!start) {
+    return 0;
+  }
+  if (ts < start->ts) {
+    bpf_map_delete_elem(&starts, &next_pid);
+    return 0;
+  }
+
+  struct OffCpuKey key;
+  __builtin_memset(&key, 0, sizeof(key));
+  key.pid = next_pid;
+  key.tgid = start->tgid;
+  key.kern_stack_id = start->kern_stack_id;
+  key.user_stack_id = start->user_stack_id;
+  bpf_probe_read_kernel_str(key.comm, sizeof(key.comm), ctx->next_comm);
+
+  u64 delta = (ts - start->ts) / 1000;
+  bpf_map_delete_elem(&starts, &next_pid);
+  if (delta == 0) {
+    return 0;
+  }
+
+  u64 *val = bpf_map_lookup_elem(&off_cpu, &key);
+  if (val) {
+    __sync_fetch_and_add(val, delta);
+  } else {
+    bpf_map_update_elem(&off_cpu, &key, &delta, BPF_NOEXIST);
+  }
+  return 0;
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hermes/common"
+	"io"
 	"os"
 	"strings"
 )
@@ -64,7 +65,20 @@ func (inst *GetBuildID) File(filePath string) (string, error) {
 		return "", err
 	}
 	defer fp.Close()
+	return inst.elfFile(fp)
+}
+
+// Reader reads the build ID of an opened ELF file, e.g. the one of a
+// mapping opened through /proc/<pid>/map_files.
+func (inst *GetBuildID) Reader(reader io.ReaderAt) (string, error) {
+	fp, err := elf.NewFile(reader)
+	if err != nil {
+		return "", err
+	}
+	return inst.elfFile(fp)
+}
 
+func (inst *GetBuildID) elfFile(fp *elf.File) (string, error) {
 	sections := []string{".note.gnu.build-id", ".notes", ".note"}
 	for _, section := range sections {
 		sec := fp.Section(section)
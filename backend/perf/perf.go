@@ -2,10 +2,13 @@ package perf
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"unsafe"
 
+	"hermes/backend/dbgsym"
+
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 )
@@ -24,6 +27,8 @@ type PerfEvent struct {
 	ringBufHandler *RingBufHandler
 	groups         []*PerfEvent
 	idGroups       map[uint64]*PerfEvent
+	// outputs are the events redirected into the ring buffer of the event
+	outputs []*PerfEvent
 }
 
 func NewPerfEvent(attr *Attr, pid, cpu int) (*PerfEvent, error) {
@@ -42,6 +47,64 @@ func NewPerfEvent(attr *Attr, pid, cpu int) (*PerfEvent, error) {
 	return &event, nil
 }
 
+// NewPerfCgroupEvent only counts the tasks in the cgroup, cgroupFd is the
+// opened cgroup v2 directory and the cpu cannot be AllCPUs.
+func NewPerfCgroupEvent(attr *Attr, cgroupFd, cpu int) (*PerfEvent, error) {
+	var event PerfEvent
+
+	if err := event.open(attr, cgroupFd, cpu, nil, unix.PERF_FLAG_PID_CGROUP); err != nil {
+		return nil, err
+	}
+	if event.attr.Sample != 0 {
+		if err := event.MapRingBuf(); err != nil {
+			event.Release()
+			return nil, err
+		}
+	}
+
+	return &event, nil
+}
+
+// NewPerfTaskEvent profiles the threads on the cpu into the ring buffer of
+// the first one opened. The events inherit, so that the threads and the
+// processes created by them while profiling are followed by the kernel,
+// the threads exited before being opened are skipped.
+func NewPerfTaskEvent(attr *Attr, tids []int, cpu int) (*PerfEvent, error) {
+	taskAttr := *attr
+	taskAttr.Options.Inherit = true
+
+	var leader *PerfEvent
+	for _, tid := range tids {
+		var event PerfEvent
+		if err := event.Open(&taskAttr, tid, cpu, nil); err != nil {
+			if errors.Is(err, unix.ESRCH) {
+				continue
+			}
+			if leader != nil {
+				leader.Release()
+			}
+			return nil, err
+		}
+		if leader == nil {
+			leader = &event
+			if err := leader.MapRingBuf(); err != nil {
+				leader.Release()
+				return nil, err
+			}
+			continue
+		}
+		leader.outputs = append(leader.outputs, &event)
+		if err := event.redirectReadRecord(leader); err != nil {
+			leader.Release()
+			return nil, err
+		}
+	}
+	if leader == nil {
+		return nil, fmt.Errorf("No thread of the targets exists")
+	}
+	return leader, nil
+}
+
 func NewPerfGroupEvent(group *Group, pid, cpu int) (*PerfEvent, error) {
 	var leader PerfEvent
 	leaderAttr := group.GetLeaderAttr()
@@ -76,6 +139,10 @@ func NewPerfGroupEvent(group *Group, pid, cpu int) (*PerfEvent, error) {
 }
 
 func (event *PerfEvent) Open(attr *Attr, pid, cpu int, groupEvent *PerfEvent) error {
+	return event.open(attr, pid, cpu, groupEvent, 0)
+}
+
+func (event *PerfEvent) open(attr *Attr, pid, cpu int, groupEvent *PerfEvent, flags int) error {
 	if event.IsValid() {
 		return nil
 	}
@@ -87,7 +154,7 @@ func (event *PerfEvent) Open(attr *Attr, pid, cpu int, groupEvent *PerfEvent) er
 		}
 		groupPerfFd = groupEvent.Fd
 	}
-	flags := unix.PERF_FLAG_FD_CLOEXEC
+	flags |= unix.PERF_FLAG_FD_CLOEXEC
 	fd, err := unix.PerfEventOpen(attr.ToUnixPerfEventAttr(), pid, cpu, groupPerfFd, flags)
 	if err != nil {
 		return os.NewSyscallError("perf_event_open", err)
@@ -127,6 +194,14 @@ func (event *PerfEvent) MapRingBuf() error {
 	return nil
 }
 
+// SetBuildIDCollector reads the build IDs of the files mapped while the event
+// is profiled, the event must have a ring buffer.
+func (event *PerfEvent) SetBuildIDCollector(buildIDs *dbgsym.BuildIDCollector) {
+	if event.ringBufHandler != nil {
+		event.ringBufHandler.buildIDs = buildIDs
+	}
+}
+
 func (event *PerfEvent) IsValid() bool {
 	return event.Fd > 0
 }
@@ -174,6 +249,11 @@ func (event *PerfEvent) Enable() error {
 	if !event.IsValid() {
 		return fmt.Errorf("Failed to enable perf because event isn't valid")
 	}
+	for _, output := range event.outputs {
+		if err := output.ioctl(unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+			return err
+		}
+	}
 	return event.ioctl(unix.PERF_EVENT_IOC_ENABLE, 0)
 }
 
@@ -181,6 +261,11 @@ func (event *PerfEvent) Disable() error {
 	if !event.IsValid() {
 		return fmt.Errorf("Failed to disable perf event because event isn't valid")
 	}
+	for _, output := range event.outputs {
+		if err := output.ioctl(unix.PERF_EVENT_IOC_DISABLE, 0); err != nil {
+			return err
+		}
+	}
 	return event.ioctl(unix.PERF_EVENT_IOC_DISABLE, 0)
 }
 
@@ -188,6 +273,11 @@ func (event *PerfEvent) Reset() error {
 	if !event.IsValid() {
 		return fmt.Errorf("Failed to reset perf event")
 	}
+	for _, output := range event.outputs {
+		if err := output.ioctl(unix.PERF_EVENT_IOC_RESET, 0); err != nil {
+			return err
+		}
+	}
 	return event.ioctl(unix.PERF_EVENT_IOC_RESET, 0)
 }
 
@@ -236,7 +326,7 @@ func (event *PerfEvent) handleReadContent() error {
 	return event.handleGroupReadContent()
 }
 
-func (event *PerfEvent) Profile(ctx context.Context, outputPath string) error {
+func (event *PerfEvent) Profile(ctx context.Context, outputPath string, options WriterOptions) error {
 	if err := event.Disable(); err != nil {
 		return err
 	}
@@ -248,7 +338,9 @@ func (event *PerfEvent) Profile(ctx context.Context, outputPath string) error {
 	}
 
 	if event.ringBufHandler != nil {
-		event.ringBufHandler.HandleRecords(outputPath)
+		if err := event.ringBufHandler.HandleRecords(outputPath, options); err != nil {
+			logrus.Errorf("Failed to create the record file [%s], err [%s]", outputPath, err)
+		}
 	}
 
 	<-ctx.Done()
@@ -257,6 +349,7 @@ func (event *PerfEvent) Profile(ctx context.Context, outputPath string) error {
 	}
 	if event.ringBufHandler != nil {
 		event.sendTermToRingBuf()
+		event.ringBufHandler.Wait()
 	}
 
 	event.handleReadContent()
@@ -270,5 +363,8 @@ func (event *PerfEvent) Release() {
 	for _, _event := range event.groups {
 		_event.Release()
 	}
+	for _, output := range event.outputs {
+		output.Release()
+	}
 	unix.Close(event.Fd)
 }
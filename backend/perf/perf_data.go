@@ -0,0 +1,296 @@
+package perf
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"unsafe"
+
+	"hermes/backend/dbgsym"
+	"hermes/backend/elf"
+	"hermes/common"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// The perf.data layout of tools/perf/util/header.h: the file header, the
+// attr with its ids, the records and the feature sections.
+const (
+	PerfDataMagic      uint64 = 0x32454c4946524550 // "PERFILE2"
+	PerfDataHeaderSize        = 104
+	PerfDataPostfix           = ".perf.data"
+)
+
+// The feature bits, the sections are written in the order of the bits.
+const (
+	HeaderBuildID   = 2
+	HeaderHostname  = 3
+	HeaderOSRelease = 4
+	HeaderVersion   = 5
+	HeaderArch      = 6
+	HeaderNrCpus    = 7
+)
+
+const (
+	perfRecordHeaderBuildID = 67
+	perfRecordMiscBuildID   = 1 << 15
+	perfNameAlign           = 64
+	perfHostPid             = -1
+)
+
+type perfFileSection struct {
+	Offset uint64
+	Size   uint64
+}
+
+type perfFileHeader struct {
+	Magic       uint64
+	Size        uint64
+	AttrSize    uint64
+	Attrs       perfFileSection
+	Data        perfFileSection
+	EventTypes  perfFileSection
+	AddFeatures [4]uint64
+}
+
+// PerfDataWriter writes the records of an attr into a perf.data file read by
+// perf report, perf script and hotspot. The synthesized records must share
+// the layout of the attr, i.e. the attr has no sample_id_all.
+type PerfDataWriter struct {
+	fp       *os.File
+	writer   *bufio.Writer
+	attr     *Attr
+	dataSize uint64
+	// the build IDs of the mmapped files read at collection time
+	mappedFiles []dbgsym.MappedFile
+}
+
+// NewPerfDataWriter writes the build IDs of the mapped files of the
+// BuildIDCollector.
+func NewPerfDataWriter(outputPath string, attr *Attr, mappedFiles []dbgsym.MappedFile) (*PerfDataWriter, error) {
+	fp, err := os.OpenFile(outputPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	inst := &PerfDataWriter{
+		fp:          fp,
+		writer:      bufio.NewWriterSize(fp, RecordBufSize),
+		attr:        attr,
+		mappedFiles: mappedFiles,
+	}
+
+	// the header is written at the close once the sizes are known
+	header := make([]byte, PerfDataHeaderSize)
+	if _, err := inst.writer.Write(header); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	unixAttr := attr.ToUnixPerfEventAttr()
+	attrBytes := (*[unsafe.Sizeof(unix.PerfEventAttr{})]byte)(unsafe.Pointer(unixAttr))
+	if _, err := inst.writer.Write(attrBytes[:]); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	// the attr has no ids as it is the only one
+	ids := make([]byte, unsafe.Sizeof(perfFileSection{}))
+	if _, err := inst.writer.Write(ids); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return inst, nil
+}
+
+func (inst *PerfDataWriter) getAttrSize() uint64 {
+	return uint64(unsafe.Sizeof(unix.PerfEventAttr{}) + unsafe.Sizeof(perfFileSection{}))
+}
+
+func (inst *PerfDataWriter) WriteRaw(raw *RawRecord) error {
+	header := (*[unsafe.Sizeof(Header{})]byte)(unsafe.Pointer(&raw.Header))
+	if _, err := inst.writer.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := inst.writer.Write(raw.Data); err != nil {
+		return err
+	}
+	inst.dataSize += uint64(len(header) + len(raw.Data))
+	return nil
+}
+
+func (inst *PerfDataWriter) Write(rec Record) error {
+	raw, err := EncodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	return inst.WriteRaw(raw)
+}
+
+// alignName pads the string with zeros to the name alignment of perf.
+func alignName(name string) []byte {
+	size := (len(name) + 1 + perfNameAlign - 1) / perfNameAlign * perfNameAlign
+	bytes := make([]byte, size)
+	copy(bytes, name)
+	return bytes
+}
+
+func (inst *PerfDataWriter) getStringFeature(val string) []byte {
+	name := alignName(val)
+	bytes := make([]byte, 4, 4+len(name))
+	common.NativeEndian().PutUint32(bytes, uint32(len(name)))
+	return append(bytes, name...)
+}
+
+func (inst *PerfDataWriter) getBuildIDEvent(misc uint16, pid int32, buildID, filename string) []byte {
+	id, err := hex.DecodeString(buildID)
+	if err != nil || len(id) == 0 || len(id) > 20 {
+		return nil
+	}
+	name := alignName(filename)
+	header := Header{
+		Type: perfRecordHeaderBuildID,
+		Misc: misc | perfRecordMiscBuildID,
+		// the header, the pid and the build ID of 24 bytes
+		Size: uint16(8 + 4 + 24 + len(name)),
+	}
+	headerBytes := (*[unsafe.Sizeof(Header{})]byte)(unsafe.Pointer(&header))
+	writer := FieldWriter(append([]byte{}, headerBytes[:]...))
+	writer.Uint32(uint32(pid))
+	data := make([]byte, 24)
+	copy(data, id)
+	data[20] = byte(len(id))
+	writer = append(writer, data...)
+	return append(writer, name...)
+}
+
+// getBuildIDFeature returns the build IDs of the kernel and of the files
+// mapped by each process.
+func (inst *PerfDataWriter) getBuildIDFeature() []byte {
+	bytes := []byte{}
+	if buildID, err := elf.NewGetBuildID().Kernel(); err == nil {
+		bytes = append(bytes, inst.getBuildIDEvent(unix.PERF_RECORD_MISC_KERNEL, perfHostPid, buildID, KernelMapName)...)
+	}
+	for _, mappedFile := range inst.mappedFiles {
+		bytes = append(bytes, inst.getBuildIDEvent(unix.PERF_RECORD_MISC_USER, int32(mappedFile.Pid), mappedFile.BuildID, mappedFile.Filename)...)
+	}
+	return bytes
+}
+
+func (inst *PerfDataWriter) getFeatures() map[int][]byte {
+	features := map[int][]byte{
+		HeaderBuildID: inst.getBuildIDFeature(),
+		HeaderVersion: inst.getStringFeature("hermes"),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		features[HeaderHostname] = inst.getStringFeature(hostname)
+	}
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err == nil {
+		features[HeaderOSRelease] = inst.getStringFeature(unix.ByteSliceToString(uname.Release[:]))
+		features[HeaderArch] = inst.getStringFeature(unix.ByteSliceToString(uname.Machine[:]))
+	}
+	nrCpus := FieldWriter{}
+	nrCpus.Uint32(uint32(runtime.NumCPU()))
+	nrCpus.Uint32(uint32(runtime.NumCPU()))
+	features[HeaderNrCpus] = nrCpus
+	return features
+}
+
+func (inst *PerfDataWriter) writeFeatures(header *perfFileHeader) error {
+	features := inst.getFeatures()
+	bits := []int{}
+	for bit := 0; bit < 256; bit++ {
+		if _, isExist := features[bit]; isExist {
+			bits = append(bits, bit)
+			header.AddFeatures[bit/64] |= 1 << (bit % 64)
+		}
+	}
+
+	offset := header.Data.Offset + header.Data.Size + uint64(len(bits))*uint64(unsafe.Sizeof(perfFileSection{}))
+	for _, bit := range bits {
+		section := perfFileSection{
+			Offset: offset,
+			Size:   uint64(len(features[bit])),
+		}
+		bytes := (*[unsafe.Sizeof(perfFileSection{})]byte)(unsafe.Pointer(&section))
+		if _, err := inst.writer.Write(bytes[:]); err != nil {
+			return err
+		}
+		offset += section.Size
+	}
+	for _, bit := range bits {
+		if _, err := inst.writer.Write(features[bit]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inst *PerfDataWriter) close() error {
+	header := perfFileHeader{
+		Magic:    PerfDataMagic,
+		Size:     PerfDataHeaderSize,
+		AttrSize: inst.getAttrSize(),
+		Attrs: perfFileSection{
+			Offset: PerfDataHeaderSize,
+			Size:   inst.getAttrSize(),
+		},
+		Data: perfFileSection{
+			Offset: PerfDataHeaderSize + inst.getAttrSize(),
+			Size:   inst.dataSize,
+		},
+	}
+	if err := inst.writeFeatures(&header); err != nil {
+		return err
+	}
+	if err := inst.writer.Flush(); err != nil {
+		return err
+	}
+
+	bytes := (*[unsafe.Sizeof(perfFileHeader{})]byte)(unsafe.Pointer(&header))
+	_, err := inst.fp.WriteAt(bytes[:], 0)
+	return err
+}
+
+func (inst *PerfDataWriter) Close() error {
+	err := inst.close()
+	if _err := inst.fp.Close(); err == nil {
+		err = _err
+	}
+	return err
+}
+
+// WritePerfData merges the binary record files into a perf.data file, the
+// attr is the one of the sampled records.
+func WritePerfData(outputPath string, attr *Attr, recordPaths []string, mappedFiles []dbgsym.MappedFile) error {
+	writer, err := NewPerfDataWriter(outputPath, attr, mappedFiles)
+	if err != nil {
+		return err
+	}
+
+	for _, recordPath := range recordPaths {
+		reader, err := NewRecordReader(recordPath)
+		if err != nil {
+			logrus.Errorf("Failed to open records [%s], err [%s]", recordPath, err)
+			continue
+		}
+		for {
+			raw, err := reader.NextRaw()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					logrus.Errorf("Failed to read records [%s], err [%s]", recordPath, err)
+				}
+				break
+			}
+			if err := writer.WriteRaw(raw); err != nil {
+				reader.Close()
+				writer.Close()
+				return err
+			}
+		}
+		reader.Close()
+	}
+	return writer.Close()
+}
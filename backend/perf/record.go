@@ -2,9 +2,9 @@ package perf
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/bits"
-	"os"
 	"sync/atomic"
 	"unsafe"
 
@@ -47,24 +47,6 @@ type Record interface {
 	Decode(raw *RawRecord, attr *Attr)
 }
 
-func AppendToFile(rec Record, outputPath string) error {
-	bytes, err := json.Marshal(rec)
-	if err != nil {
-		return err
-	}
-
-	fp, err := os.OpenFile(outputPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-	defer fp.Close()
-
-	if _, err = fp.WriteString(string(bytes) + "\n"); err != nil {
-		return err
-	}
-	return nil
-}
-
 type SampleID struct {
 	Pid        uint32 `json:"pid"`
 	Tid        uint32 `json:"tid"`
@@ -663,9 +645,13 @@ func (parser *RecordParser) getRawRecord() *RawRecord {
 	return &raw
 }
 
-func (parser *RecordParser) newRecord(raw *RawRecord) (Record, error) {
+var ErrUnhandledType = errors.New("Unhandled type")
+
+// newRecord allocates the record of the type, the read values are grouped
+// with group.
+func newRecord(recType RecordType, group bool) (Record, error) {
 	var rec Record
-	switch raw.Header.Type {
+	switch recType {
 	case MmapRec:
 		rec = &MmapRecord{}
 	case LostRec:
@@ -681,13 +667,13 @@ func (parser *RecordParser) newRecord(raw *RawRecord) (Record, error) {
 	case ForkRec:
 		rec = &ForkRecord{}
 	case ReadRec:
-		if parser.attr.ReadFormat.Group {
+		if group {
 			rec = &GroupReadRecord{}
 		} else {
 			rec = &ReadRecord{}
 		}
 	case SampleRec:
-		if parser.attr.ReadFormat.Group {
+		if group {
 			rec = &GroupSampleRecord{}
 		} else {
 			rec = &SampleRecord{}
@@ -707,17 +693,49 @@ func (parser *RecordParser) newRecord(raw *RawRecord) (Record, error) {
 	case NamespacesRec:
 		rec = &NamespacesRecord{}
 	default:
-		return nil, fmt.Errorf("Unhandled type [%d]", int(raw.Header.Type))
+		return nil, fmt.Errorf("%w [%d]", ErrUnhandledType, int(recType))
+	}
+	return rec, nil
+}
+
+// DecodeRecord decodes a raw record of the ring buffer of the attr.
+func DecodeRecord(raw *RawRecord, attr *Attr) (Record, error) {
+	rec, err := newRecord(raw.Header.Type, attr.ReadFormat.Group)
+	if err != nil {
+		return nil, err
+	}
+	rec.Decode(raw, attr)
+	return rec, nil
+}
+
+// UnmarshalRecord decodes a record in a JSON line.
+func UnmarshalRecord(bytes []byte) (Record, error) {
+	var header Header
+	if err := json.Unmarshal(bytes, &header); err != nil {
+		return nil, err
+	}
+	// the group read values are left out of the sample and read records
+	rec, err := newRecord(header.Type, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, rec); err != nil {
+		return nil, err
 	}
-	rec.Decode(raw, parser.attr)
 	return rec, nil
 }
 
+// GetRawRecord returns the next record of the ring buffer undecoded, or nil
+// if it is empty.
+func (parser *RecordParser) GetRawRecord() *RawRecord {
+	return parser.getRawRecord()
+}
+
 func (parser *RecordParser) GetRecord() (Record, error) {
 	raw := parser.getRawRecord()
 	if raw == nil {
 		return nil, nil
 	}
 
-	return parser.newRecord(raw)
+	return DecodeRecord(raw, parser.attr)
 }
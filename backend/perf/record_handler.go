@@ -1,23 +1,34 @@
 package perf
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
 	"sort"
+	"strings"
 
+	"hermes/backend/dbgsym"
 	"hermes/backend/symbol"
 	"hermes/backend/utils"
 
+	"github.com/google/pprof/profile"
 	"golang.org/x/sys/unix"
 )
 
 const (
-	AnonComm = "anon"
-	DbgDir   = ".hermes.perf.dbg"
+	AnonComm      = "anon"
+	KernelMapName = "[kernel.kallsyms]"
 )
 
+// The perf-map and jitdump files of a JIT runtime are snapshotted into the
+// log data directory with the pid appended to these postfixes.
+const (
+	PerfMapPostfix = ".perf.map_"
+	JitDumpPostfix = ".perf.jitdump_"
+)
+
+// BuildIDsPostfix is the file of the build IDs of the mapped files read by
+// the BuildIDCollector.
+const BuildIDsPostfix = ".perf.build_ids"
+
 const (
 	KernelThreadPid = 0
 	KernelThreadTid = 0
@@ -26,7 +37,18 @@ const (
 type Map struct {
 	start   uint64
 	end     uint64
+	pgoff   uint64
 	buildID string
+	// anon maps hold the code generated by JIT runtimes
+	anon bool
+	// module is the name of a kernel module map
+	module   string
+	filename string
+}
+
+// GetOffset returns the file offset of a user-space ip.
+func (inst *Map) GetOffset(ip uint64) uint64 {
+	return ip - inst.start + inst.pgoff
 }
 
 type Maps []Map
@@ -48,14 +70,27 @@ type ThreadInfo struct {
 	maps Maps
 }
 
-func (inst *ThreadInfo) Find(ip uint64) (string, error) {
+func (inst *ThreadInfo) Find(ip uint64) (*Map, error) {
 	idx := sort.Search(len(inst.maps), func(i int) bool {
-		return ip >= inst.maps[i].start && ip <= inst.maps[i].end
-	})
-	if idx < len(inst.maps) {
-		return inst.maps[idx].buildID, nil
+		return inst.maps[i].start > ip
+	}) - 1
+	if idx >= 0 && ip < inst.maps[idx].end {
+		return &inst.maps[idx], nil
+	}
+	return nil, fmt.Errorf("Failed to find thread info with ip [%d]", ip)
+}
+
+// addMap replaces the maps starting within the new one.
+func (inst *ThreadInfo) addMap(_map Map) {
+	maps := Maps{}
+	for _, __map := range inst.maps {
+		if __map.start < _map.start || __map.start >= _map.end {
+			maps = append(maps, __map)
+		}
 	}
-	return "", fmt.Errorf("Failed to find thread info with ip [%d]", ip)
+	maps = append(maps, _map)
+	sort.Sort(maps)
+	inst.maps = maps
 }
 
 type ThreadsInfo map[uint64]ThreadInfo
@@ -76,6 +111,15 @@ func (inst *ThreadsInfo) SetComm(comm string, pid, tid uint32) {
 	}
 }
 
+// AddMap records the maps in the main thread, they are shared by all the
+// threads of the process.
+func (inst *ThreadsInfo) AddMap(pid uint32, _map Map) {
+	index := inst.getIndex(pid, pid)
+	threadInfo := (*inst)[index]
+	threadInfo.addMap(_map)
+	(*inst)[index] = threadInfo
+}
+
 func (inst *ThreadsInfo) Find(pid, tid uint32) *ThreadInfo {
 	index := inst.getIndex(pid, tid)
 	if threadInfo, isExist := (*inst)[index]; isExist {
@@ -84,73 +128,275 @@ func (inst *ThreadsInfo) Find(pid, tid uint32) *ThreadInfo {
 	return nil
 }
 
+type mappedFileKey struct {
+	pid      uint32
+	filename string
+}
+
 type RecordHandler struct {
-	dbgDirPath     string
+	dbgDirPath string
+	// logDbgDirPath holds the binaries copied at collection time
+	logDbgDirPath  string
 	flameGraphData *utils.FlameGraphData
-	threadsInfo    ThreadsInfo
-	symbolizer     symbol.Symbolizer
+	// lineFlameGraphData expands the inlined frames with the source lines, it
+	// is nil unless the line detail is enabled
+	lineFlameGraphData *utils.FlameGraphData
+	threadsInfo        ThreadsInfo
+	symbolizer         symbol.Symbolizer
+	buildIDs           map[mappedFileKey]string
+	preparedBuildIDs   map[string]bool
+	jitSymbols         map[uint32]*symbol.JitSymbols
+	kernelBuildID      string
+	pprofData          *utils.PprofData
 }
 
-func NewRecordHandler() (*RecordHandler, error) {
-	homeDir, err := os.UserHomeDir()
+// NewRecordHandler demangles the C++ and Rust symbols unless rawSymbols is
+// set, lineDetail reads the DWARF of the binaries for the flame graph of the
+// inlined frames and source lines.
+func NewRecordHandler(rawSymbols, lineDetail bool) (*RecordHandler, error) {
+	dbgDirPath, err := symbol.GetDbgDirPath()
 	if err != nil {
 		return nil, err
 	}
-	dbgDirPath := filepath.Join(homeDir, DbgDir)
+
+	var lineFlameGraphData *utils.FlameGraphData
+	if lineDetail {
+		lineFlameGraphData = utils.NewFlameGraphData()
+	}
 
 	return &RecordHandler{
-		dbgDirPath:     dbgDirPath,
-		flameGraphData: utils.NewFlameGraphData(),
-		threadsInfo:    ThreadsInfo{},
-		symbolizer:     *symbol.NewSymbolizer(dbgDirPath),
+		dbgDirPath:         dbgDirPath,
+		flameGraphData:     utils.NewFlameGraphData(),
+		lineFlameGraphData: lineFlameGraphData,
+		threadsInfo:        ThreadsInfo{},
+		symbolizer:         *symbol.NewSymbolizer(dbgDirPath, rawSymbols),
+		buildIDs:           map[mappedFileKey]string{},
+		preparedBuildIDs:   map[string]bool{},
+		jitSymbols:         map[uint32]*symbol.JitSymbols{},
+		pprofData:          utils.NewPprofData("samples", "count"),
 	}, nil
 }
 
-func (inst *RecordHandler) parseCommRec(bytes []byte) error {
-	var rec CommRecord
-	if err := json.Unmarshal(bytes, &rec); err != nil {
+func (inst *RecordHandler) parseCommRec(rec *CommRecord) error {
+	inst.threadsInfo.SetComm(rec.Comm, rec.Pid, rec.Tid)
+	return nil
+}
+
+// LoadBuildIDs loads the build IDs of the mapped files read at collection
+// time, logDbgDirPath is the directory of the binaries copied by them.
+func (inst *RecordHandler) LoadBuildIDs(filePath, logDbgDirPath string) error {
+	mappedFiles, err := dbgsym.LoadMappedFiles(filePath)
+	if err != nil {
 		return err
 	}
-
-	inst.threadsInfo.SetComm(rec.Comm, rec.Pid, rec.Tid)
+	inst.logDbgDirPath = logDbgDirPath
+	for _, mappedFile := range mappedFiles {
+		inst.buildIDs[mappedFileKey{pid: mappedFile.Pid, filename: mappedFile.Filename}] = mappedFile.BuildID
+	}
 	return nil
 }
 
-func (inst *RecordHandler) parseSymbol(pid, tid uint32, cpuMode symbol.CpuMode, ip uint64) string {
-	_symbol := fmt.Sprintf("0x%x", ip)
-	if cpuMode == symbol.UnknownMode {
-		return _symbol
+// getBuildID returns the build ID of the file mapped by the process, the
+// binary is linked into the debug directory from the copy of the collection
+// or from the host path if it has the build ID.
+func (inst *RecordHandler) getBuildID(pid uint32, filename string) string {
+	buildID := inst.buildIDs[mappedFileKey{pid: pid, filename: filename}]
+	if buildID == "" {
+		return ""
+	}
+	// prepared once, the build ID is still kept by the pprof mappings when no
+	// binary is found
+	if !inst.preparedBuildIDs[buildID] {
+		logBinaryPath := dbgsym.NewBuildID(dbgsym.UserMode, "", inst.logDbgDirPath).GetUserBinaryPath(buildID)
+		symbol.UserSymPrepare(inst.dbgDirPath, buildID, []string{logBinaryPath, filename})
+		inst.preparedBuildIDs[buildID] = true
+	}
+	return buildID
+}
+
+func isAnonFile(filename string) bool {
+	return filename == "" || filename == "//anon" ||
+		strings.HasPrefix(filename, "[anon") || strings.HasPrefix(filename, "/memfd:")
+}
+
+func (inst *RecordHandler) getJitSymbols(pid uint32) *symbol.JitSymbols {
+	jitSymbols, isExist := inst.jitSymbols[pid]
+	if !isExist {
+		jitSymbols = symbol.NewJitSymbols()
+		inst.jitSymbols[pid] = jitSymbols
+	}
+	return jitSymbols
+}
+
+// LoadPerfMap loads the /tmp/perf-<pid>.map snapshot of a process.
+func (inst *RecordHandler) LoadPerfMap(pid uint32, filePath string) error {
+	return inst.getJitSymbols(pid).LoadPerfMap(filePath)
+}
+
+// LoadJitDump loads the jit-<pid>.dump snapshot of a process.
+func (inst *RecordHandler) LoadJitDump(pid uint32, filePath string) error {
+	return inst.getJitSymbols(pid).LoadJitDump(filePath)
+}
+
+// resolveJit names the ips in anonymous executable maps, or in no map at
+// all, by the JIT symbols of the process.
+func (inst *RecordHandler) resolveJit(pid uint32, ip uint64) (string, bool) {
+	jitSymbols, isExist := inst.jitSymbols[pid]
+	if !isExist {
+		return "", false
+	}
+	if threadInfo := inst.threadsInfo.Find(pid, pid); threadInfo != nil {
+		if _map, err := threadInfo.Find(ip); err == nil && !_map.anon {
+			return "", false
+		}
+	}
+	name := jitSymbols.Resolve(ip)
+	return name, name != ""
+}
+
+func (inst *RecordHandler) parseMmapRec(rec *Mmap2Record) error {
+	// the kernel mmap records have no prot while the synthesized ones have
+	if rec.Prot != 0 && rec.Prot&unix.PROT_EXEC == 0 {
+		return nil
+	}
+
+	if isAnonFile(rec.Filename) {
+		inst.threadsInfo.AddMap(rec.Pid, Map{
+			start:    rec.Addr,
+			end:      rec.Addr + rec.Len,
+			anon:     true,
+			filename: rec.Filename,
+		})
+		return nil
 	}
 
+	// the files without a build ID are symbolized by their paths
+	inst.threadsInfo.AddMap(rec.Pid, Map{
+		start:    rec.Addr,
+		end:      rec.Addr + rec.Len,
+		pgoff:    rec.Pgoff,
+		buildID:  inst.getBuildID(rec.Pid, rec.Filename),
+		filename: rec.Filename,
+	})
+	return nil
+}
+
+// locate returns the map of the ip and the address to symbolize, the file
+// offset for user-space.
+func (inst *RecordHandler) locate(pid uint32, cpuMode symbol.CpuMode, ip uint64) (*Map, uint64, bool) {
 	var threadInfo *ThreadInfo
 	switch cpuMode {
 	case symbol.KernelMode:
 		threadInfo = inst.threadsInfo.Find(0, 0)
 	case symbol.UserMode:
-		threadInfo = inst.threadsInfo.Find(pid, tid)
+		threadInfo = inst.threadsInfo.Find(pid, pid)
 	}
 
 	if threadInfo == nil {
-		return _symbol
+		return nil, 0, false
 	}
 
-	buildID, err := threadInfo.Find(ip)
+	_map, err := threadInfo.Find(ip)
 	if err != nil {
-		return _symbol
+		return nil, 0, false
+	}
+	addr := ip
+	if cpuMode == symbol.UserMode {
+		addr = _map.GetOffset(ip)
+	}
+	return _map, addr, true
+}
+
+// parseSymbol returns the function of the ip, its inlined frames with the
+// source lines for the line detail and its map. The frames are empty when
+// the ip isn't symbolized.
+func (inst *RecordHandler) parseSymbol(pid uint32, cpuMode symbol.CpuMode, ip uint64) (string, []symbol.Frame, *Map) {
+	if cpuMode == symbol.UserMode {
+		if name, ok := inst.resolveJit(pid, ip); ok {
+			return name, []symbol.Frame{{Function: name}}, nil
+		}
+	}
+
+	_symbol := fmt.Sprintf("0x%x", ip)
+	_map, addr, ok := inst.locate(pid, cpuMode, ip)
+	if !ok {
+		return _symbol, nil, nil
+	}
+	buildID := _map.buildID
+	if _map.module != "" {
+		__symbol, err := inst.symbolizer.SymbolizeModule(buildID, _map.module, addr)
+		if err != nil {
+			return _symbol, nil, _map
+		}
+		return __symbol, []symbol.Frame{{Function: __symbol}}, _map
 	}
-	if __symbol, err := inst.symbolizer.Symbolize(cpuMode, buildID, ip); err == nil {
+
+	var __symbol string
+	var err error
+	if cpuMode == symbol.UserMode && buildID == "" {
+		__symbol, err = inst.symbolizer.SymbolizePath(_map.filename, addr)
+	} else {
+		__symbol, err = inst.symbolizer.Symbolize(cpuMode, buildID, addr)
+	}
+	if err == nil {
 		_symbol = __symbol
 	}
+	// the DWARF is only found by the build ID
+	if inst.lineFlameGraphData == nil || buildID == "" {
+		if err != nil {
+			return _symbol, nil, _map
+		}
+		return _symbol, []symbol.Frame{{Function: _symbol}}, _map
+	}
+	frames, err := inst.symbolizer.SymbolizeFrames(cpuMode, buildID, addr)
+	if err != nil {
+		return _symbol, nil, _map
+	}
+	return _symbol, frames, _map
+}
+
+// Symbolize returns the function of the ip of the process, or the ip in hex
+// if it isn't symbolized.
+func (inst *RecordHandler) Symbolize(pid uint32, cpuMode symbol.CpuMode, ip uint64) string {
+	_symbol, _, _ := inst.parseSymbol(pid, cpuMode, ip)
 	return _symbol
 }
 
-func (inst *RecordHandler) parseSampleRec(bytes []byte) error {
-	var rec SampleRecord
-	if err := json.Unmarshal(bytes, &rec); err != nil {
-		return err
+// getPprofBuildID returns the build ID of a map, the kernel maps are named by
+// the ID of their kallsyms.
+func (inst *RecordHandler) getPprofBuildID(_map *Map) string {
+	if _map.filename == KernelMapName || _map.module != "" {
+		return dbgsym.GetBuildIDBySymID(_map.buildID)
 	}
+	return _map.buildID
+}
 
+func (inst *RecordHandler) getPprofLocation(_map *Map, ip uint64, frames []symbol.Frame) *profile.Location {
+	var mapping *profile.Mapping
+	if _map != nil {
+		mapping = inst.pprofData.AddMapping(utils.PprofMapping{
+			Start:   _map.start,
+			Limit:   _map.end,
+			Offset:  _map.pgoff,
+			File:    _map.filename,
+			BuildID: inst.getPprofBuildID(_map),
+		})
+	}
+	pprofFrames := make([]utils.PprofFrame, 0, len(frames))
+	for _, frame := range frames {
+		pprofFrames = append(pprofFrames, utils.PprofFrame{
+			Function: frame.Function,
+			File:     frame.File,
+			Line:     int64(frame.Line),
+		})
+	}
+	return inst.pprofData.AddLocation(mapping, ip, pprofFrames)
+}
+
+func (inst *RecordHandler) parseSampleRec(rec *SampleRecord) error {
 	stack := []string{}
+	lineStack := []string{}
+	locations := []*profile.Location{}
 	cpuMode := symbol.UnknownMode
 	if rec.Misc&unix.PERF_RECORD_MISC_KERNEL == unix.PERF_RECORD_MISC_KERNEL {
 		cpuMode = symbol.KernelMode
@@ -159,15 +405,39 @@ func (inst *RecordHandler) parseSampleRec(bytes []byte) error {
 	}
 
 	for _, ip := range rec.CallchainIps {
-		_symbol := inst.parseSymbol(rec.Pid, rec.Tid, cpuMode, ip)
+		// the context markers switch the mode of the following ips
+		if int64(ip) < 0 && int64(ip) >= unix.PERF_CONTEXT_MAX {
+			switch int64(ip) {
+			case unix.PERF_CONTEXT_KERNEL:
+				cpuMode = symbol.KernelMode
+			case unix.PERF_CONTEXT_USER:
+				cpuMode = symbol.UserMode
+			default:
+				cpuMode = symbol.UnknownMode
+			}
+			continue
+		}
+		_symbol, frames, _map := inst.parseSymbol(rec.Pid, cpuMode, ip)
 		stack = append(stack, _symbol)
+		if len(frames) == 0 {
+			lineStack = append(lineStack, _symbol)
+		}
+		for _, frame := range frames {
+			lineStack = append(lineStack, frame.String())
+		}
+		locations = append(locations, inst.getPprofLocation(_map, ip, frames))
 	}
+	comm := AnonComm
 	if threadInfo := inst.threadsInfo.Find(rec.Pid, rec.Tid); threadInfo != nil {
-		stack = append(stack, threadInfo.Comm)
-	} else {
-		stack = append(stack, AnonComm)
+		comm = threadInfo.Comm
 	}
+	stack = append(stack, comm)
+	lineStack = append(lineStack, comm)
 	inst.flameGraphData.Add(&stack, len(stack)-1, 1)
+	if inst.lineFlameGraphData != nil {
+		inst.lineFlameGraphData.Add(&lineStack, len(lineStack)-1, 1)
+	}
+	inst.pprofData.AddSample(locations, 1, map[string]string{"comm": comm}, map[string]int64{"pid": int64(rec.Pid)})
 	return nil
 }
 
@@ -181,9 +451,10 @@ func (inst *RecordHandler) createKernelMap(_buildID string) error {
 		Comm: "",
 		maps: Maps{
 			{
-				start:   start,
-				end:     end,
-				buildID: _buildID,
+				start:    start,
+				end:      end,
+				buildID:  _buildID,
+				filename: KernelMapName,
 			},
 		},
 	}
@@ -195,24 +466,78 @@ func (inst *RecordHandler) PrepareKernelSymbol(kernSymPath string) error {
 	if err != nil {
 		return err
 	}
+	inst.kernelBuildID = buildID
 	return inst.createKernelMap(buildID)
 }
 
-func (inst *RecordHandler) Parse(bytes []byte) error {
-	var header Header
-	if err := json.Unmarshal(bytes, &header); err != nil {
+// PrepareKernelModules adds the maps of the modules loaded at collection
+// time, it must follow PrepareKernelSymbol.
+func (inst *RecordHandler) PrepareKernelModules(modulesPath string) error {
+	modules, err := symbol.LoadKernelModules(modulesPath)
+	if err != nil {
 		return err
 	}
+	for _, module := range modules {
+		inst.threadsInfo.AddMap(KernelThreadPid, Map{
+			start:    module.Start,
+			end:      module.End,
+			buildID:  inst.kernelBuildID,
+			module:   module.Name,
+			filename: "[" + module.Name + "]",
+		})
+	}
+	return nil
+}
 
-	switch header.Type {
-	case CommRec:
-		return inst.parseCommRec(bytes)
-	case SampleRec:
-		return inst.parseSampleRec(bytes)
+// Handle adds a record to the flame graphs, the threads and their maps are
+// tracked by the comm and mmap records.
+func (inst *RecordHandler) Handle(rec Record) error {
+	switch rec := rec.(type) {
+	case *CommRecord:
+		return inst.parseCommRec(rec)
+	case *MmapRecord:
+		return inst.parseMmapRec(&Mmap2Record{
+			Header:   rec.Header,
+			Pid:      rec.Pid,
+			Tid:      rec.Tid,
+			Addr:     rec.Addr,
+			Len:      rec.Len,
+			Pgoff:    rec.Pgoff,
+			Filename: rec.Filename,
+		})
+	case *Mmap2Record:
+		return inst.parseMmapRec(rec)
+	case *SampleRecord:
+		return inst.parseSampleRec(rec)
+	case *GroupSampleRecord:
+		return inst.parseSampleRec(&SampleRecord{
+			Header:       rec.Header,
+			Pid:          rec.Pid,
+			Tid:          rec.Tid,
+			CallchainIps: rec.CallchainIps,
+		})
 	}
 	return nil
 }
 
+// Parse handles a record in a JSON line.
+func (inst *RecordHandler) Parse(bytes []byte) error {
+	rec, err := UnmarshalRecord(bytes)
+	if err != nil {
+		return err
+	}
+	return inst.Handle(rec)
+}
+
 func (inst *RecordHandler) GetFlameGraphData() *utils.FlameGraphData {
 	return inst.flameGraphData
 }
+
+func (inst *RecordHandler) GetPprofData() *utils.PprofData {
+	return inst.pprofData
+}
+
+// GetLineFlameGraphData returns nil unless the line detail is enabled.
+func (inst *RecordHandler) GetLineFlameGraphData() *utils.FlameGraphData {
+	return inst.lineFlameGraphData
+}
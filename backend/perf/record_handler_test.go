@@ -0,0 +1,29 @@
+package perf
+
+import "testing"
+
+func TestThreadInfoFind(t *testing.T) {
+	var threadInfo ThreadInfo
+	threadInfo.addMap(Map{start: 0x1000, end: 0x2000, filename: "a"})
+	threadInfo.addMap(Map{start: 0x3000, end: 0x4000, filename: "b"})
+
+	for _, test := range []struct {
+		ip       uint64
+		filename string
+	}{
+		{0xfff, ""},
+		{0x1000, "a"},
+		{0x1fff, "a"},
+		{0x2000, ""},
+		{0x3000, "b"},
+		{0x4000, ""},
+	} {
+		filename := ""
+		if _map, err := threadInfo.Find(test.ip); err == nil {
+			filename = _map.filename
+		}
+		if filename != test.filename {
+			t.Errorf("Find [0x%x] = [%s], expected [%s]", test.ip, filename, test.filename)
+		}
+	}
+}
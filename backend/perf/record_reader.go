@@ -0,0 +1,168 @@
+package perf
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"unsafe"
+
+	"hermes/common"
+)
+
+// The lengths of the blocks are checked before they are allocated, a record
+// is bounded by the u16 size of its header and the attr is bounded apart.
+const (
+	MaxRecordBlockSize = math.MaxUint16
+	MaxAttrBlockSize   = 1 << 20
+)
+
+// RecordReader reads the records of a file of RecordWriter, the JSON lines
+// of the debug format are read as well.
+type RecordReader struct {
+	fp       *os.File
+	reader   *bufio.Reader
+	gzReader *gzip.Reader
+	attr     *Attr
+	isJson   bool
+	lenBuf   [4]byte
+}
+
+func NewRecordReader(filePath string) (*RecordReader, error) {
+	fp, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	inst := &RecordReader{
+		fp:     fp,
+		reader: bufio.NewReaderSize(fp, RecordBufSize),
+	}
+	if err := inst.readHeader(); err != nil {
+		fp.Close()
+		return nil, fmt.Errorf("Failed to read the header of [%s], err [%s]", filePath, err)
+	}
+	return inst, nil
+}
+
+func (inst *RecordReader) readHeader() error {
+	header, err := inst.reader.Peek(8)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if len(header) < 8 || common.NativeEndian().Uint32(header) != RecordFileMagic {
+		inst.isJson = true
+		return nil
+	}
+	flags := common.NativeEndian().Uint32(header[4:])
+	inst.reader.Discard(8)
+
+	if flags&RecordFileGzip != 0 {
+		gzReader, err := gzip.NewReader(inst.reader)
+		if err != nil {
+			return err
+		}
+		inst.gzReader = gzReader
+	}
+
+	bytes, err := inst.readBlock(MaxAttrBlockSize)
+	if err != nil {
+		return err
+	}
+	inst.attr = &Attr{}
+	return json.Unmarshal(bytes, inst.attr)
+}
+
+func (inst *RecordReader) getReader() io.Reader {
+	if inst.gzReader != nil {
+		return inst.gzReader
+	}
+	return inst.reader
+}
+
+func (inst *RecordReader) readBlock(maxSize uint32) ([]byte, error) {
+	reader := inst.getReader()
+	if _, err := io.ReadFull(reader, inst.lenBuf[:]); err != nil {
+		return nil, err
+	}
+	size := common.NativeEndian().Uint32(inst.lenBuf[:])
+	if size > maxSize {
+		return nil, fmt.Errorf("Unexpected block size [%d], max [%d]", size, maxSize)
+	}
+	block := make([]byte, size)
+	if _, err := io.ReadFull(reader, block); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return block, nil
+}
+
+func (inst *RecordReader) readJson() (Record, error) {
+	for {
+		line, err := inst.reader.ReadBytes('\n')
+		if len(line) == 0 || (len(line) == 1 && line[0] == '\n') {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return UnmarshalRecord(line)
+	}
+}
+
+// Next returns the next record, or io.EOF at the end of the file. The
+// records of the unhandled types are skipped.
+func (inst *RecordReader) Next() (Record, error) {
+	for {
+		rec, err := inst.next()
+		if errors.Is(err, ErrUnhandledType) {
+			continue
+		}
+		return rec, err
+	}
+}
+
+func (inst *RecordReader) next() (Record, error) {
+	if inst.isJson {
+		return inst.readJson()
+	}
+
+	raw, err := inst.NextRaw()
+	if err != nil {
+		return nil, err
+	}
+	return DecodeRecord(raw, inst.attr)
+}
+
+// NextRaw returns the next record undecoded, only the binary records are
+// kept raw.
+func (inst *RecordReader) NextRaw() (*RawRecord, error) {
+	if inst.isJson {
+		return nil, fmt.Errorf("The raw records of JSON lines don't exist")
+	}
+
+	block, err := inst.readBlock(MaxRecordBlockSize)
+	if err != nil {
+		return nil, err
+	}
+	var raw RawRecord
+	headerSize := int(unsafe.Sizeof(raw.Header))
+	if len(block) < headerSize {
+		return nil, fmt.Errorf("Unexpected record size [%d]", len(block))
+	}
+	raw.Header = *(*Header)(unsafe.Pointer(&block[0]))
+	raw.Data = block[headerSize:]
+	return &raw, nil
+}
+
+func (inst *RecordReader) Close() error {
+	if inst.gzReader != nil {
+		inst.gzReader.Close()
+	}
+	return inst.fp.Close()
+}
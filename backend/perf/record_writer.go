@@ -0,0 +1,263 @@
+package perf
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+
+	"hermes/common"
+)
+
+type RecordFormat string
+
+const (
+	// BinaryFormat keeps the records as they are in the ring buffer
+	BinaryFormat RecordFormat = "binary"
+	// JsonFormat decodes the records into JSON lines for debugging
+	JsonFormat RecordFormat = "json"
+)
+
+// The binary record file starts with the magic and the flags, followed by
+// the length-prefixed attr in JSON and the length-prefixed raw records.
+// The part after the flags is gzipped with RecordFileGzip.
+const (
+	RecordFileMagic uint32 = 0x534d5248 // "HRMS"
+	RecordFileGzip  uint32 = 1 << 0
+)
+
+const RecordBufSize = 64 * 1024
+
+type WriterOptions struct {
+	Format   RecordFormat
+	Compress bool
+}
+
+func (options *WriterOptions) Check() error {
+	switch options.Format {
+	case "", BinaryFormat, JsonFormat:
+		return nil
+	}
+	return fmt.Errorf("Unrecognized record format [%s]", options.Format)
+}
+
+// RecordWriter buffers the records of a ring buffer into a file, it is not
+// safe for concurrent use and must be closed to flush.
+type RecordWriter interface {
+	WriteRaw(raw *RawRecord) error
+	Write(rec Record) error
+	Close() error
+}
+
+func NewRecordWriter(outputPath string, attr *Attr, options WriterOptions) (RecordWriter, error) {
+	fp, err := os.OpenFile(outputPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if options.Format == JsonFormat {
+		return &jsonRecordWriter{
+			fp:     fp,
+			writer: bufio.NewWriterSize(fp, RecordBufSize),
+			attr:   attr,
+		}, nil
+	}
+
+	writer, err := newBinaryRecordWriter(fp, attr, options.Compress)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return writer, nil
+}
+
+type jsonRecordWriter struct {
+	fp     *os.File
+	writer *bufio.Writer
+	attr   *Attr
+}
+
+func (inst *jsonRecordWriter) WriteRaw(raw *RawRecord) error {
+	rec, err := DecodeRecord(raw, inst.attr)
+	if err != nil {
+		return err
+	}
+	return inst.Write(rec)
+}
+
+func (inst *jsonRecordWriter) Write(rec Record) error {
+	bytes, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := inst.writer.Write(bytes); err != nil {
+		return err
+	}
+	return inst.writer.WriteByte('\n')
+}
+
+func (inst *jsonRecordWriter) Close() error {
+	err := inst.writer.Flush()
+	if _err := inst.fp.Close(); err == nil {
+		err = _err
+	}
+	return err
+}
+
+type binaryRecordWriter struct {
+	fp       *os.File
+	writer   *bufio.Writer
+	gzWriter *gzip.Writer
+	attr     *Attr
+	lenBuf   [4]byte
+}
+
+func newBinaryRecordWriter(fp *os.File, attr *Attr, compress bool) (*binaryRecordWriter, error) {
+	inst := &binaryRecordWriter{
+		fp:     fp,
+		writer: bufio.NewWriterSize(fp, RecordBufSize),
+		attr:   attr,
+	}
+
+	var header [8]byte
+	flags := uint32(0)
+	if compress {
+		flags |= RecordFileGzip
+	}
+	common.NativeEndian().PutUint32(header[:4], RecordFileMagic)
+	common.NativeEndian().PutUint32(header[4:], flags)
+	if _, err := inst.writer.Write(header[:]); err != nil {
+		return nil, err
+	}
+	if compress {
+		gzWriter, err := gzip.NewWriterLevel(inst.writer, gzip.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		inst.gzWriter = gzWriter
+	}
+
+	bytes, err := json.Marshal(attr)
+	if err != nil {
+		return nil, err
+	}
+	if err := inst.writeBlock(bytes); err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+func (inst *binaryRecordWriter) getWriter() io.Writer {
+	if inst.gzWriter != nil {
+		return inst.gzWriter
+	}
+	return inst.writer
+}
+
+func (inst *binaryRecordWriter) writeBlock(blocks ...[]byte) error {
+	size := 0
+	for _, block := range blocks {
+		size += len(block)
+	}
+	writer := inst.getWriter()
+	common.NativeEndian().PutUint32(inst.lenBuf[:], uint32(size))
+	if _, err := writer.Write(inst.lenBuf[:]); err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if _, err := writer.Write(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteRaw writes the header and the data of the record as in the ring
+// buffer, they are decoded by the reader.
+func (inst *binaryRecordWriter) WriteRaw(raw *RawRecord) error {
+	header := (*[unsafe.Sizeof(Header{})]byte)(unsafe.Pointer(&raw.Header))
+	return inst.writeBlock(header[:], raw.Data)
+}
+
+func (inst *binaryRecordWriter) Write(rec Record) error {
+	raw, err := EncodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	return inst.WriteRaw(raw)
+}
+
+func (inst *binaryRecordWriter) Close() error {
+	var err error
+	if inst.gzWriter != nil {
+		err = inst.gzWriter.Close()
+	}
+	if _err := inst.writer.Flush(); err == nil {
+		err = _err
+	}
+	if _err := inst.fp.Close(); err == nil {
+		err = _err
+	}
+	return err
+}
+
+// FieldWriter is the counterpart of FieldParser to encode the synthesized
+// records.
+type FieldWriter []byte
+
+func (writer *FieldWriter) Uint64(val uint64) {
+	var buf [8]byte
+	common.NativeEndian().PutUint64(buf[:], val)
+	*writer = append(*writer, buf[:]...)
+}
+
+func (writer *FieldWriter) Uint32(val uint32) {
+	var buf [4]byte
+	common.NativeEndian().PutUint32(buf[:], val)
+	*writer = append(*writer, buf[:]...)
+}
+
+// String writes the string with the terminating zero padded to 8 bytes.
+func (writer *FieldWriter) String(val string) {
+	*writer = append(*writer, val...)
+	padding := 8 - len(val)%8
+	for i := 0; i < padding; i++ {
+		*writer = append(*writer, 0)
+	}
+}
+
+// EncodeRecord encodes the records synthesized from procfs, without sample
+// ID as the attr of the synthesized events is empty.
+func EncodeRecord(rec Record) (*RawRecord, error) {
+	var raw RawRecord
+	writer := FieldWriter{}
+	switch rec := rec.(type) {
+	case *CommRecord:
+		raw.Header = rec.Header
+		writer.Uint32(rec.Pid)
+		writer.Uint32(rec.Tid)
+		writer.String(rec.Comm)
+	case *Mmap2Record:
+		raw.Header = rec.Header
+		raw.Header.Type = Mmap2Rec
+		writer.Uint32(rec.Pid)
+		writer.Uint32(rec.Tid)
+		writer.Uint64(rec.Addr)
+		writer.Uint64(rec.Len)
+		writer.Uint64(rec.Pgoff)
+		writer.Uint32(rec.MajorID)
+		writer.Uint32(rec.MinorID)
+		writer.Uint64(rec.Ino)
+		writer.Uint64(rec.InoGeneration)
+		writer.Uint32(rec.Prot)
+		writer.Uint32(rec.Flags)
+		writer.String(rec.Filename)
+	default:
+		return nil, fmt.Errorf("Unhandled record [%T]", rec)
+	}
+	raw.Data = writer
+	raw.Header.Size = uint16(unsafe.Sizeof(raw.Header)) + uint16(len(raw.Data))
+	return &raw, nil
+}
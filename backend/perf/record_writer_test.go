@@ -0,0 +1,139 @@
+package perf
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"hermes/common"
+)
+
+func getTestRecords() []Record {
+	return []Record{
+		&CommRecord{
+			Header: Header{Type: CommRec},
+			Pid:    1,
+			Tid:    2,
+			Comm:   "comm",
+		},
+		&Mmap2Record{
+			Header:   Header{Type: Mmap2Rec},
+			Pid:      1,
+			Tid:      1,
+			Addr:     0x400000,
+			Len:      0x1000,
+			Pgoff:    0x2000,
+			Prot:     4,
+			Flags:    2,
+			Filename: "/usr/bin/true",
+		},
+	}
+}
+
+func writeTestRecords(t *testing.T, filePath string, options WriterOptions) []Record {
+	writer, err := NewRecordWriter(filePath, &Attr{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := getTestRecords()
+	for _, rec := range recs {
+		if err := writer.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
+
+func readTestRecords(t *testing.T, filePath string) ([]Record, error) {
+	reader, err := NewRecordReader(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	recs := []Record{}
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestRecordWriterRoundTrip(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		filePath := filepath.Join(t.TempDir(), "records")
+		expected := writeTestRecords(t, filePath, WriterOptions{Format: BinaryFormat, Compress: compress})
+		recs, err := readTestRecords(t, filePath)
+		if err != nil {
+			t.Fatalf("Failed to read records of compress [%t], err [%s]", compress, err)
+		}
+		if len(recs) != len(expected) {
+			t.Fatalf("Unexpected records [%d] of compress [%t], expected [%d]", len(recs), compress, len(expected))
+		}
+		for i := range recs {
+			// the sizes are set by the encoding
+			switch rec := recs[i].(type) {
+			case *CommRecord:
+				rec.Size = 0
+			case *Mmap2Record:
+				rec.Size = 0
+			}
+			if !reflect.DeepEqual(recs[i], expected[i]) {
+				t.Fatalf("Unexpected record [%+v] of compress [%t], expected [%+v]", recs[i], compress, expected[i])
+			}
+		}
+	}
+}
+
+func TestRecordReaderTruncated(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "records")
+	writeTestRecords(t, filePath, WriterOptions{Format: BinaryFormat})
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filePath, info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := readTestRecords(t, filePath)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Unexpected err [%v] of truncated records", err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("Unexpected records [%d] before the truncated one", len(recs))
+	}
+}
+
+func TestRecordReaderBlockSize(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "records")
+	writeTestRecords(t, filePath, WriterOptions{Format: BinaryFormat})
+	fp, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lenBuf [4]byte
+	common.NativeEndian().PutUint32(lenBuf[:], MaxRecordBlockSize+1)
+	if _, err := fp.Write(lenBuf[:]); err != nil {
+		t.Fatal(err)
+	}
+	fp.Close()
+
+	recs, err := readTestRecords(t, filePath)
+	if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Unexpected err [%v] of oversized block", err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("Unexpected records [%d] before the oversized one", len(recs))
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 	"unsafe"
 
+	"hermes/backend/dbgsym"
+
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 )
@@ -31,6 +33,9 @@ type RingBufHandler struct {
 	termFd  int
 	parser  *RecordParser
 	timeout chan time.Duration
+	done    chan struct{}
+	// buildIDs reads the build IDs of the files mapped while profiling
+	buildIDs *dbgsym.BuildIDCollector
 }
 
 func NewRingBufHandler(perfFd int, attr *Attr) (*RingBufHandler, error) {
@@ -69,6 +74,7 @@ func NewRingBufHandler(perfFd int, attr *Attr) (*RingBufHandler, error) {
 		termFd:  termFd,
 		parser:  parser,
 		timeout: make(chan time.Duration),
+		done:    make(chan struct{}),
 	}, nil
 }
 
@@ -95,25 +101,49 @@ func (handler *RingBufHandler) poll() PollResp {
 	}
 }
 
-func (handler *RingBufHandler) parseRecords(outputPath string) error {
+// parseRecords drains the ring buffer into the writer, the records are only
+// decoded by the JSON writer.
+func (handler *RingBufHandler) parseRecords(writer RecordWriter) error {
 	for {
-		rec, err := handler.parser.GetRecord()
-		if err != nil {
-			logrus.Errorf("Failed to get record [%s]", err)
-			return err
-		}
-		if rec == nil {
+		raw := handler.parser.GetRawRecord()
+		if raw == nil {
 			break
 		}
 
-		if err := AppendToFile(rec, outputPath); err != nil {
-			logrus.Errorf("Failed to append the record to file [%s], err [%s]", outputPath, err)
+		if handler.buildIDs != nil {
+			handler.addBuildID(raw)
+		}
+		if err := writer.WriteRaw(raw); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (handler *RingBufHandler) handleRecords(outputPath string) {
+func (handler *RingBufHandler) addBuildID(raw *RawRecord) {
+	if raw.Header.Type != MmapRec && raw.Header.Type != Mmap2Rec {
+		return
+	}
+	rec, err := DecodeRecord(raw, handler.attr)
+	if err != nil {
+		return
+	}
+	switch rec := rec.(type) {
+	case *MmapRecord:
+		handler.buildIDs.Add(rec.Pid, rec.Addr, rec.Addr+rec.Len, rec.Filename)
+	case *Mmap2Record:
+		handler.buildIDs.Add(rec.Pid, rec.Addr, rec.Addr+rec.Len, rec.Filename)
+	}
+}
+
+func (handler *RingBufHandler) handleRecords(writer RecordWriter) {
+	defer close(handler.done)
+	defer func() {
+		if err := writer.Close(); err != nil {
+			logrus.Errorf("Failed to close the record writer, err [%s]", err)
+		}
+	}()
+
 	for {
 		pollResp := handler.poll()
 		if pollResp.Term {
@@ -122,18 +152,30 @@ func (handler *RingBufHandler) handleRecords(outputPath string) {
 			logrus.Errorf("Failed to poll ring buffer [%s]", pollResp.Err)
 		}
 
-		if err := handler.parseRecords(outputPath); err != nil {
-			logrus.Errorf("Failed to get records from ring buffer [%s]")
+		if err := handler.parseRecords(writer); err != nil {
+			logrus.Errorf("Failed to get records from ring buffer [%s]", err)
 		}
 	}
 
-	if err := handler.parseRecords(outputPath); err != nil {
+	if err := handler.parseRecords(writer); err != nil {
 		logrus.Errorf("Failed to get records from ring buffer [%s]", err)
 	}
 }
 
-func (handler *RingBufHandler) HandleRecords(outputPath string) {
-	go handler.handleRecords(outputPath)
+// HandleRecords writes the records of the ring buffer in the background
+// until the term, Wait returns once they are flushed.
+func (handler *RingBufHandler) HandleRecords(outputPath string, options WriterOptions) error {
+	writer, err := NewRecordWriter(outputPath, handler.attr, options)
+	if err != nil {
+		close(handler.done)
+		return err
+	}
+	go handler.handleRecords(writer)
+	return nil
+}
+
+func (handler *RingBufHandler) Wait() {
+	<-handler.done
 }
 
 func (handler *RingBufHandler) Release() {
@@ -9,19 +9,40 @@ import (
 	"strings"
 	"syscall"
 
+	"hermes/backend/dbgsym"
+
 	"github.com/sirupsen/logrus"
 )
 
 type SynthesizeEvents struct {
 	outputPath string
+	writer     RecordWriter
+	buildIDs   *dbgsym.BuildIDCollector
 }
 
-func NewSynthesizeEvents(outputPath string) (*SynthesizeEvents, error) {
+// NewSynthesizeEvents writes the events in the format of the options, they
+// must be closed to be flushed.
+func NewSynthesizeEvents(outputPath string, options WriterOptions) (*SynthesizeEvents, error) {
+	writer, err := NewRecordWriter(outputPath, &Attr{}, options)
+	if err != nil {
+		return nil, err
+	}
 	return &SynthesizeEvents{
 		outputPath: outputPath,
+		writer:     writer,
 	}, nil
 }
 
+// SetBuildIDCollector reads the build IDs of the executable maps as they are
+// synthesized.
+func (events *SynthesizeEvents) SetBuildIDCollector(buildIDs *dbgsym.BuildIDCollector) {
+	events.buildIDs = buildIDs
+}
+
+func (events *SynthesizeEvents) Close() error {
+	return events.writer.Close()
+}
+
 func (events *SynthesizeEvents) synthesizeCommEvents(pid, tid int) error {
 	path := filepath.Join("/proc", strconv.Itoa(tid), "status")
 	fp, err := os.Open(path)
@@ -51,10 +72,7 @@ func (events *SynthesizeEvents) synthesizeCommEvents(pid, tid int) error {
 		}
 	}
 
-	if err := AppendToFile(&rec, events.outputPath); err != nil {
-		return err
-	}
-	return nil
+	return events.writer.Write(&rec)
 }
 
 func (events *SynthesizeEvents) parseProcMapsLine(data string, rec *Mmap2Record) error {
@@ -76,7 +94,7 @@ func (events *SynthesizeEvents) parseProcMapsLine(data string, rec *Mmap2Record)
 		return err
 	}
 	rec.Addr = uint64(start)
-	rec.Len = uint64(end)
+	rec.Len = uint64(end - start)
 
 	for _, c := range tokens[1] {
 		if c == '-' {
@@ -153,10 +171,14 @@ func (events *SynthesizeEvents) synthesizeMmap2Events(pid, tid int) error {
 	}
 	scanner := bufio.NewScanner(fp)
 	for scanner.Scan() {
+		rec.Prot = 0
 		if err := events.parseProcMapsLine(string(scanner.Bytes()), &rec); err != nil {
 			continue
 		}
-		if err := AppendToFile(&rec, events.outputPath); err != nil {
+		if events.buildIDs != nil && rec.Prot&syscall.PROT_EXEC != 0 {
+			events.buildIDs.Add(rec.Pid, rec.Addr, rec.Addr+rec.Len, rec.Filename)
+		}
+		if err := events.writer.Write(&rec); err != nil {
 			logrus.Errorf("Failed to append record to file [%s], err [%s]",
 				events.outputPath, err)
 		}
@@ -164,6 +186,27 @@ func (events *SynthesizeEvents) synthesizeMmap2Events(pid, tid int) error {
 	return nil
 }
 
+func (events *SynthesizeEvents) synthesizeProcess(pid int) error {
+	path := filepath.Join("/proc", strconv.Itoa(pid), "task")
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			return err
+		}
+		if err := events.synthesizeCommEvents(pid, tid); err != nil {
+			logrus.Errorf("Failed to synthesize comm events, err [%s]", err)
+		}
+		if err := events.synthesizeMmap2Events(pid, tid); err != nil {
+			logrus.Errorf("Failed to synthesize mmap events, err [%s]", err)
+		}
+	}
+	return nil
+}
+
 func (events *SynthesizeEvents) Synthesize() error {
 	entries, err := os.ReadDir("/proc")
 	if err != nil {
@@ -175,22 +218,19 @@ func (events *SynthesizeEvents) Synthesize() error {
 		if err != nil {
 			continue
 		}
-		path := filepath.Join("/proc", entry.Name(), "task")
-		_entries, err := os.ReadDir(path)
-		if err != nil {
+		if err := events.synthesizeProcess(pid); err != nil {
 			return err
 		}
-		for _, _entry := range _entries {
-			tid, err := strconv.Atoi(_entry.Name())
-			if err != nil {
-				return err
-			}
-			if err := events.synthesizeCommEvents(pid, tid); err != nil {
-				logrus.Errorf("Failed to synthesize comm events, err [%s]", err)
-			}
-			if err := events.synthesizeMmap2Events(pid, tid); err != nil {
-				logrus.Errorf("Failed to synthesize mmap events, err [%s]", err)
-			}
+	}
+	return nil
+}
+
+// SynthesizePids only synthesizes the events of the given processes, the
+// ones that have exited are skipped.
+func (events *SynthesizeEvents) SynthesizePids(pids []int32) error {
+	for _, pid := range pids {
+		if err := events.synthesizeProcess(int(pid)); err != nil {
+			logrus.Errorf("Failed to synthesize events of process [%d], err [%s]", pid, err)
 		}
 	}
 	return nil
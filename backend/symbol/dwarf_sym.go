@@ -0,0 +1,223 @@
+package symbol
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"sync"
+
+	"hermes/backend/dbgsym"
+
+	"github.com/golang/groupcache/lru"
+)
+
+// Frame is a function at a source line, an address expands into several
+// frames when functions are inlined into it.
+type Frame struct {
+	Function string `json:"function"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+}
+
+func (frame *Frame) String() string {
+	if frame.File == "" {
+		return frame.Function
+	}
+	return fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line)
+}
+
+type dwarfData struct {
+	data   *dwarf.Data
+	mutex  *sync.Mutex
+	frames map[uint64][]Frame
+}
+
+type DwarfParser struct {
+	dbgDirPath string
+	mutex      *sync.Mutex
+	cache      *lru.Cache
+}
+
+func NewDwarfParser(dbgDirPath string) *DwarfParser {
+	return &DwarfParser{
+		dbgDirPath: dbgDirPath,
+		mutex:      &sync.Mutex{},
+		cache:      lru.New(LRUCacheSize),
+	}
+}
+
+// load reads the DWARF of the debuginfo, or of the binary if it isn't
+// stripped.
+func (inst *DwarfParser) load(buildID string) (*dwarfData, error) {
+	fetchDebugInfo(inst.dbgDirPath, buildID)
+	dbgBuildID := dbgsym.NewBuildID(dbgsym.UserMode, "", inst.dbgDirPath)
+	for _, filePath := range []string{dbgBuildID.GetUserPath(buildID), dbgBuildID.GetUserBinaryPath(buildID)} {
+		fp, err := elf.Open(filePath)
+		if err != nil {
+			continue
+		}
+		data, err := fp.DWARF()
+		fp.Close()
+		if err != nil {
+			continue
+		}
+		return &dwarfData{
+			data:   data,
+			mutex:  &sync.Mutex{},
+			frames: map[uint64][]Frame{},
+		}, nil
+	}
+	return nil, fmt.Errorf("Failed to find DWARF of build ID [%s]", buildID)
+}
+
+func (inst *DwarfParser) getDwarfData(buildID string) (*dwarfData, error) {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	if data, ok := inst.cache.Get(buildID); ok {
+		if data == nil {
+			return nil, fmt.Errorf("Failed to load DWARF of build ID [%s]", buildID)
+		}
+		return data.(*dwarfData), nil
+	}
+
+	data, err := inst.load(buildID)
+	if err != nil {
+		inst.cache.Add(buildID, nil)
+		return nil, err
+	}
+	inst.cache.Add(buildID, data)
+	return data, nil
+}
+
+func (inst *dwarfData) containsPC(entry *dwarf.Entry, pc uint64) bool {
+	ranges, err := inst.data.Ranges(entry)
+	if err != nil {
+		return false
+	}
+	for _, _range := range ranges {
+		if pc >= _range[0] && pc < _range[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// getName follows the abstract origin and specification of inlined and
+// out-of-line instances to the declaration with the name, the linkage name
+// is preferred as it is qualified.
+func (inst *dwarfData) getName(entry *dwarf.Entry) string {
+	for depth := 0; entry != nil && depth < 8; depth++ {
+		if name, ok := entry.Val(dwarf.AttrLinkageName).(string); ok {
+			return name
+		}
+		if name, ok := entry.Val(dwarf.AttrName).(string); ok {
+			return name
+		}
+		offset, ok := entry.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+		if !ok {
+			if offset, ok = entry.Val(dwarf.AttrSpecification).(dwarf.Offset); !ok {
+				return ""
+			}
+		}
+		reader := inst.data.Reader()
+		reader.Seek(offset)
+		if entry, _ = reader.Next(); entry == nil {
+			return ""
+		}
+	}
+	return ""
+}
+
+// getScopes returns the subprogram containing the pc followed by the
+// inlined subroutines down to the innermost one.
+func (inst *dwarfData) getScopes(reader *dwarf.Reader, pc uint64) []*dwarf.Entry {
+	scopes := []*dwarf.Entry{}
+	for depth := 1; depth > 0; {
+		entry, err := reader.Next()
+		if err != nil || entry == nil {
+			break
+		}
+		if entry.Tag == 0 {
+			depth--
+			continue
+		}
+
+		isScope := entry.Tag == dwarf.TagSubprogram || entry.Tag == dwarf.TagInlinedSubroutine ||
+			entry.Tag == dwarf.TagLexDwarfBlock
+		if !isScope || !inst.containsPC(entry, pc) {
+			if entry.Children {
+				reader.SkipChildren()
+			}
+			continue
+		}
+		if entry.Tag != dwarf.TagLexDwarfBlock {
+			scopes = append(scopes, entry)
+		}
+		if entry.Children {
+			depth++
+		}
+	}
+	return scopes
+}
+
+func (inst *dwarfData) resolve(pc uint64) ([]Frame, error) {
+	reader := inst.data.Reader()
+	cu, err := reader.SeekPC(pc)
+	if err != nil {
+		return nil, err
+	}
+	scopes := inst.getScopes(reader, pc)
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("Failed to find function of [0x%x]", pc)
+	}
+
+	lineReader, err := inst.data.LineReader(cu)
+	if err != nil || lineReader == nil {
+		return nil, fmt.Errorf("Failed to read line table of [0x%x]", pc)
+	}
+	files := lineReader.Files()
+
+	frames := make([]Frame, len(scopes))
+	var lineEntry dwarf.LineEntry
+	if err := lineReader.SeekPC(pc, &lineEntry); err == nil && lineEntry.File != nil {
+		frames[0].File = lineEntry.File.Name
+		frames[0].Line = lineEntry.Line
+	}
+	// the innermost scope is at the line of the pc, each inlined scope sets
+	// the call site of its caller
+	for i := range scopes {
+		scope := scopes[len(scopes)-1-i]
+		frames[i].Function = inst.getName(scope)
+		if i+1 == len(scopes) {
+			break
+		}
+		if idx, ok := scope.Val(dwarf.AttrCallFile).(int64); ok && idx >= 0 && int(idx) < len(files) && files[idx] != nil {
+			frames[i+1].File = files[idx].Name
+		}
+		if line, ok := scope.Val(dwarf.AttrCallLine).(int64); ok {
+			frames[i+1].Line = int(line)
+		}
+	}
+	return frames, nil
+}
+
+// Resolve returns the frames of the virtual address from the innermost
+// inlined function.
+func (inst *DwarfParser) Resolve(buildID string, addr uint64) ([]Frame, error) {
+	data, err := inst.getDwarfData(buildID)
+	if err != nil {
+		return nil, err
+	}
+
+	data.mutex.Lock()
+	defer data.mutex.Unlock()
+	if frames, isExist := data.frames[addr]; isExist {
+		return frames, nil
+	}
+	frames, err := data.resolve(addr)
+	if err != nil {
+		return nil, err
+	}
+	data.frames[addr] = frames
+	return frames, nil
+}
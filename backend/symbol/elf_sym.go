@@ -0,0 +1,285 @@
+package symbol
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"hermes/backend/dbgsym"
+	elfUtils "hermes/backend/elf"
+
+	"github.com/golang/groupcache/lru"
+	"github.com/sirupsen/logrus"
+)
+
+type elfSymbol struct {
+	addr uint64
+	size uint64
+	name string
+}
+
+func sortSymbols(syms []elfSymbol) {
+	sort.SliceStable(syms, func(i, j int) bool {
+		return syms[i].addr < syms[j].addr
+	})
+}
+
+// resolveSymbol returns the symbol containing the address, the symbols are
+// sorted by address.
+func resolveSymbol(syms []elfSymbol, addr uint64) string {
+	idx := sort.Search(len(syms), func(i int) bool {
+		return syms[i].addr > addr
+	}) - 1
+	if idx < 0 {
+		return ""
+	}
+	sym := &syms[idx]
+	if sym.size != 0 && addr >= sym.addr+sym.size {
+		return ""
+	}
+	return sym.name
+}
+
+// ElfSymbols are the function symbols of an ELF file sorted by address, the
+// loadable segments translate file offsets to virtual addresses.
+type ElfSymbols struct {
+	syms  []elfSymbol
+	loads []elf.ProgHeader
+}
+
+func (inst *ElfSymbols) addSymbols(syms []elf.Symbol) {
+	for _, sym := range syms {
+		symType := elf.ST_TYPE(sym.Info)
+		if (symType != elf.STT_FUNC && symType != elf.STT_GNU_IFUNC) || sym.Value == 0 || sym.Name == "" {
+			continue
+		}
+		inst.syms = append(inst.syms, elfSymbol{
+			addr: sym.Value,
+			size: sym.Size,
+			name: sym.Name,
+		})
+	}
+}
+
+func (inst *ElfSymbols) addLoads(fp *elf.File) {
+	if len(inst.loads) != 0 {
+		return
+	}
+	for _, prog := range fp.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_X != 0 {
+			inst.loads = append(inst.loads, prog.ProgHeader)
+		}
+	}
+}
+
+// GetAddr translates a file offset to the virtual address of the ELF.
+func (inst *ElfSymbols) GetAddr(offset uint64) (uint64, bool) {
+	for _, load := range inst.loads {
+		if offset >= load.Off && offset < load.Off+load.Filesz {
+			return offset - load.Off + load.Vaddr, true
+		}
+	}
+	return 0, false
+}
+
+func (inst *ElfSymbols) Resolve(addr uint64) string {
+	return resolveSymbol(inst.syms, addr)
+}
+
+type ElfSymParser struct {
+	dbgDirPath string
+	mutex      *sync.Mutex
+	cache      *lru.Cache
+}
+
+func NewElfSymParser(dbgDirPath string) *ElfSymParser {
+	return &ElfSymParser{
+		dbgDirPath: dbgDirPath,
+		mutex:      &sync.Mutex{},
+		cache:      lru.New(LRUCacheSize),
+	}
+}
+
+func (inst *ElfSymParser) loadFile(filePath string, elfSymbols *ElfSymbols) error {
+	fp, err := elf.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	if syms, err := fp.Symbols(); err == nil {
+		elfSymbols.addSymbols(syms)
+	}
+	if syms, err := fp.DynamicSymbols(); err == nil {
+		elfSymbols.addSymbols(syms)
+	}
+	elfSymbols.addLoads(fp)
+	return nil
+}
+
+// debugInfoFetches are the build IDs whose debuginfo has been fetched by the
+// parsers, a build ID is fetched once even if the servers don't have it.
+var debugInfoFetches = struct {
+	mutex   sync.Mutex
+	enabled bool
+	fetches map[string]*sync.Once
+}{
+	fetches: map[string]*sync.Once{},
+}
+
+// SetFetchDebugInfo sets whether the parsers download the debuginfo of the
+// binaries from the debuginfod servers, it is off by default as every
+// binary takes a round trip to the servers.
+func SetFetchDebugInfo(enabled bool) {
+	debugInfoFetches.mutex.Lock()
+	defer debugInfoFetches.mutex.Unlock()
+	debugInfoFetches.enabled = enabled
+}
+
+// fetchDebugInfo downloads the debuginfo of the binary of the build ID into
+// the debug directory before its symbols are loaded.
+func fetchDebugInfo(dbgDirPath, buildID string) {
+	key := filepath.Join(dbgDirPath, buildID)
+	debugInfoFetches.mutex.Lock()
+	if !debugInfoFetches.enabled {
+		debugInfoFetches.mutex.Unlock()
+		return
+	}
+	fetch, isExist := debugInfoFetches.fetches[key]
+	if !isExist {
+		fetch = &sync.Once{}
+		debugInfoFetches.fetches[key] = fetch
+	}
+	debugInfoFetches.mutex.Unlock()
+
+	fetch.Do(func() {
+		binaryPath := dbgsym.NewBuildID(dbgsym.UserMode, "", dbgDirPath).GetUserBinaryPath(buildID)
+		_, err := dbgsym.NewBuildID(dbgsym.UserMode, binaryPath, dbgDirPath).Build()
+		if err != nil && !errors.Is(err, dbgsym.ErrNoServers) {
+			logrus.Warnf("Failed to fetch debuginfo of build ID [%s], err [%s]", buildID, err)
+		}
+	})
+}
+
+// load reads the symbols of the binary and of its debuginfo when present.
+func (inst *ElfSymParser) load(buildID string) (*ElfSymbols, error) {
+	fetchDebugInfo(inst.dbgDirPath, buildID)
+	dbgBuildID := dbgsym.NewBuildID(dbgsym.UserMode, "", inst.dbgDirPath)
+	elfSymbols := &ElfSymbols{}
+	loaded := false
+	for _, filePath := range []string{dbgBuildID.GetUserBinaryPath(buildID), dbgBuildID.GetUserPath(buildID)} {
+		if err := inst.loadFile(filePath, elfSymbols); err == nil {
+			loaded = true
+		}
+	}
+	if !loaded {
+		return nil, fmt.Errorf("Failed to find elf file of build ID [%s]", buildID)
+	}
+	sortSymbols(elfSymbols.syms)
+	return elfSymbols, nil
+}
+
+// loadPath reads the symbols of the ELF at the path, for the files without
+// a build ID.
+func (inst *ElfSymParser) loadPath(filePath string) (*ElfSymbols, error) {
+	elfSymbols := &ElfSymbols{}
+	if err := inst.loadFile(filePath, elfSymbols); err != nil {
+		return nil, err
+	}
+	sortSymbols(elfSymbols.syms)
+	return elfSymbols, nil
+}
+
+// getSymbols returns the cached symbols of the key, a build ID or a path,
+// or loads them.
+func (inst *ElfSymParser) getSymbols(key string, load func() (*ElfSymbols, error)) (*ElfSymbols, error) {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	if elfSymbols, ok := inst.cache.Get(key); ok {
+		if elfSymbols == nil {
+			return nil, fmt.Errorf("Failed to load symbols of [%s]", key)
+		}
+		return elfSymbols.(*ElfSymbols), nil
+	}
+
+	elfSymbols, err := load()
+	if err != nil {
+		// remember the failure so that the files are not read again
+		inst.cache.Add(key, nil)
+		return nil, err
+	}
+	inst.cache.Add(key, elfSymbols)
+	return elfSymbols, nil
+}
+
+func (inst *ElfSymParser) GetSymbols(buildID string) (*ElfSymbols, error) {
+	return inst.getSymbols(buildID, func() (*ElfSymbols, error) {
+		return inst.load(buildID)
+	})
+}
+
+func (inst *ElfSymParser) resolve(elfSymbols *ElfSymbols, offset uint64) string {
+	addr, ok := elfSymbols.GetAddr(offset)
+	if !ok {
+		return ""
+	}
+	return elfSymbols.Resolve(addr)
+}
+
+// Resolve returns the function at the file offset of the ELF.
+func (inst *ElfSymParser) Resolve(buildID string, offset uint64) string {
+	elfSymbols, err := inst.GetSymbols(buildID)
+	if err != nil {
+		return ""
+	}
+	return inst.resolve(elfSymbols, offset)
+}
+
+// ResolvePath returns the function at the file offset of the ELF at the
+// path, the file isn't verified to be the one mapped.
+func (inst *ElfSymParser) ResolvePath(filePath string, offset uint64) string {
+	elfSymbols, err := inst.getSymbols(filePath, func() (*ElfSymbols, error) {
+		return inst.loadPath(filePath)
+	})
+	if err != nil {
+		return ""
+	}
+	return inst.resolve(elfSymbols, offset)
+}
+
+// UserSymPrepare links the first of the files with the build ID into the
+// debug directory, e.g. the binary copied at collection time or the one on
+// the host. The link is replaced once its file is gone or has changed.
+func UserSymPrepare(dbgDirPath, buildID string, filePaths []string) error {
+	getBuildID := elfUtils.NewGetBuildID()
+	binaryPath := dbgsym.NewBuildID(dbgsym.UserMode, "", dbgDirPath).GetUserBinaryPath(buildID)
+	if _buildID, err := getBuildID.File(binaryPath); err == nil && _buildID == buildID {
+		return nil
+	}
+
+	for _, filePath := range filePaths {
+		if _buildID, err := getBuildID.File(filePath); err != nil || _buildID != buildID {
+			continue
+		}
+		absPath, err := filepath.Abs(filePath)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(binaryPath), os.ModePerm); err != nil {
+			return err
+		}
+		// renamed over the stale link as the parsers may prepare it at once
+		tmpPath := binaryPath + "." + strconv.Itoa(os.Getpid()) + ".tmp"
+		os.Remove(tmpPath)
+		if err := os.Symlink(absPath, tmpPath); err != nil {
+			return err
+		}
+		return os.Rename(tmpPath, binaryPath)
+	}
+	return fmt.Errorf("Failed to find binary of build ID [%s]", buildID)
+}
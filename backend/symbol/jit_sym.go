@@ -0,0 +1,134 @@
+package symbol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	JitDumpMagic    = 0x4A695444
+	jitCodeLoad     = 0
+	jitCodeMove     = 1
+	jitHeaderSize   = 40
+	jitRecordHeader = 16
+)
+
+// JitSymbols are the functions generated by a JIT runtime at absolute
+// addresses of its anonymous executable mappings.
+type JitSymbols struct {
+	syms []elfSymbol
+}
+
+func NewJitSymbols() *JitSymbols {
+	return &JitSymbols{}
+}
+
+func (inst *JitSymbols) add(addr, size uint64, name string) {
+	inst.syms = append(inst.syms, elfSymbol{
+		addr: addr,
+		size: size,
+		name: name,
+	})
+}
+
+// LoadPerfMap reads the "START SIZE name" lines of /tmp/perf-<pid>.map, the
+// numbers are in hex.
+func (inst *JitSymbols) LoadPerfMap(filePath string) error {
+	fp, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		tokens := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 3)
+		if len(tokens) != 3 {
+			continue
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(tokens[0], "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+		size, err := strconv.ParseUint(strings.TrimPrefix(tokens[1], "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+		inst.add(addr, size, tokens[2])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	sortSymbols(inst.syms)
+	return nil
+}
+
+// LoadJitDump reads the code load and move records of a jitdump file, the
+// byte order is given by its magic.
+func (inst *JitSymbols) LoadJitDump(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	if len(data) < jitHeaderSize {
+		return fmt.Errorf("Unexpected jitdump size [%d]", len(data))
+	}
+
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if binary.LittleEndian.Uint32(data) != JitDumpMagic {
+		if binary.BigEndian.Uint32(data) != JitDumpMagic {
+			return fmt.Errorf("Unexpected jitdump magic [0x%x]", binary.LittleEndian.Uint32(data))
+		}
+		byteOrder = binary.BigEndian
+	}
+
+	names := map[uint64]string{}
+	offset := int(byteOrder.Uint32(data[8:]))
+	for offset+jitRecordHeader <= len(data) {
+		id := byteOrder.Uint32(data[offset:])
+		size := int(byteOrder.Uint32(data[offset+4:]))
+		if size < jitRecordHeader || offset+size > len(data) {
+			break
+		}
+		record := data[offset+jitRecordHeader : offset+size]
+		offset += size
+
+		switch id {
+		case jitCodeLoad:
+			// pid, tid, vma, code_addr, code_size, code_index, name
+			if len(record) < 40 {
+				continue
+			}
+			addr := byteOrder.Uint64(record[16:])
+			codeSize := byteOrder.Uint64(record[24:])
+			codeIndex := byteOrder.Uint64(record[32:])
+			name, err := bytes.NewBuffer(record[40:]).ReadString(0)
+			if err != nil && err != io.EOF {
+				continue
+			}
+			name = strings.TrimSuffix(name, "\x00")
+			names[codeIndex] = name
+			inst.add(addr, codeSize, name)
+		case jitCodeMove:
+			// pid, tid, vma, old_code_addr, new_code_addr, code_size, code_index
+			if len(record) < 48 {
+				continue
+			}
+			if name, isExist := names[byteOrder.Uint64(record[40:])]; isExist {
+				inst.add(byteOrder.Uint64(record[24:]), byteOrder.Uint64(record[32:]), name)
+			}
+		}
+	}
+	sortSymbols(inst.syms)
+	return nil
+}
+
+func (inst *JitSymbols) Resolve(addr uint64) string {
+	return resolveSymbol(inst.syms, addr)
+}
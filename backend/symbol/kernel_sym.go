@@ -3,18 +3,46 @@ package symbol
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"unsafe"
 
 	"hermes/backend/dbgsym"
-	"hermes/common"
 
+	"github.com/golang/groupcache/lru"
 	"github.com/sirupsen/logrus"
 )
 
+// KallsymsIndex is a kallsyms file loaded once and sorted by address with
+// the symbols of the modules, the symbols of each module are also sorted
+// apart. The kallsyms have no size, the addresses from _end up to the first
+// module symbol are not resolved.
+type KallsymsIndex struct {
+	syms    []elfSymbol
+	modules map[string][]elfSymbol
+}
+
+func (inst *KallsymsIndex) Resolve(addr uint64) string {
+	return resolveSymbol(inst.syms, addr)
+}
+
+func (inst *KallsymsIndex) ResolveModule(module string, addr uint64) string {
+	return resolveSymbol(inst.modules[module], addr)
+}
+
+// kallsymsIndexes are shared by the parsers of all the profiles. They are
+// indexed by the ID of KernelSymPrepare, the file of an ID never changes.
+// Every run has its own ID, the least recently used indexes are dropped.
+var kallsymsIndexes = struct {
+	mutex   sync.Mutex
+	indexes *lru.Cache
+}{
+	indexes: lru.New(LRUCacheSize),
+}
+
 type KsymParser struct {
 	dbgDirPath string
 }
@@ -29,7 +57,9 @@ func unsafeString(bytes []byte) string {
 	return *((*string)(unsafe.Pointer(&bytes)))
 }
 
-type IteratorCallback func(uint64, string, string) error
+// IteratorCallback is called with the address, type, name and module of the
+// symbols, the module is empty for the core kernel.
+type IteratorCallback func(uint64, string, string, string) error
 
 var ErrFound = errors.New("Found")
 
@@ -52,13 +82,17 @@ func (inst *KsymParser) iterator(filePath string, callback IteratorCallback) err
 		symbolType := string(bytes[17:18])
 		symbolEndIdx := len(bytes)
 		for i := 19; i < len(bytes); i++ {
-			if bytes[i] == ' ' {
+			if bytes[i] == ' ' || bytes[i] == '\t' {
 				symbolEndIdx = i
 				break
 			}
 		}
 		symbol := string(bytes[19:symbolEndIdx])
-		if err = callback(addr, symbolType, symbol); err != nil {
+		module := ""
+		if symbolEndIdx < len(bytes) {
+			module = strings.Trim(strings.TrimSpace(string(bytes[symbolEndIdx:])), "[]")
+		}
+		if err = callback(addr, symbolType, symbol, module); err != nil {
 			return err
 		}
 	}
@@ -69,9 +103,57 @@ func (inst *KsymParser) iterator(filePath string, callback IteratorCallback) err
 	return nil
 }
 
+func (inst *KsymParser) loadIndex(filePath string) (*KallsymsIndex, error) {
+	index := &KallsymsIndex{
+		modules: map[string][]elfSymbol{},
+	}
+	err := inst.iterator(filePath, func(addr uint64, symbolType, symbol, module string) error {
+		sym := elfSymbol{
+			addr: addr,
+			name: symbol,
+		}
+		if module == "" && symbol == "_end" {
+			// the end of the core kernel
+			sym.name = ""
+		} else if module != "" {
+			index.modules[module] = append(index.modules[module], sym)
+		}
+		index.syms = append(index.syms, sym)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortSymbols(index.syms)
+	for _, syms := range index.modules {
+		sortSymbols(syms)
+	}
+	return index, nil
+}
+
+// GetIndex returns the index of the kallsyms of the ID, it is loaded at the
+// first use.
+func (inst *KsymParser) GetIndex(symID string) (*KallsymsIndex, error) {
+	kallsymsIndexes.mutex.Lock()
+	defer kallsymsIndexes.mutex.Unlock()
+	if val, isExist := kallsymsIndexes.indexes.Get(symID); isExist {
+		index := val.(*KallsymsIndex)
+		if index == nil {
+			return nil, fmt.Errorf("Failed to load kallsyms of [%s]", symID)
+		}
+		return index, nil
+	}
+
+	filePath := dbgsym.NewBuildID(dbgsym.KernelMode, "", inst.dbgDirPath).GetKernelPath(symID)
+	index, err := inst.loadIndex(filePath)
+	// remember the failure so that the file is not read again
+	kallsymsIndexes.indexes.Add(symID, index)
+	return index, err
+}
+
 func (inst *KsymParser) getFunctionAddr(filePath, sym string) (uint64, error) {
 	var addr uint64 = 0
-	err := inst.iterator(filePath, func(_addr uint64, symbolType, symbol string) error {
+	err := inst.iterator(filePath, func(_addr uint64, symbolType, symbol, module string) error {
 		_symbolType := strings.ToUpper(symbolType)
 		if (_symbolType == "T" || _symbolType == "W" || symbolType == "A") && sym == symbol {
 			addr = _addr
@@ -87,7 +169,7 @@ func (inst *KsymParser) getFunctionAddr(filePath, sym string) (uint64, error) {
 
 func (inst *KsymParser) getSymbolAddr(filePath, sym string) (uint64, error) {
 	var addr uint64 = 0
-	err := inst.iterator(filePath, func(_addr uint64, symbolType, symbol string) error {
+	err := inst.iterator(filePath, func(_addr uint64, symbolType, symbol, module string) error {
 		if sym == symbol {
 			addr = _addr
 			return ErrFound
@@ -129,30 +211,83 @@ func (inst *KsymParser) GetMapRange(_buildID string) (uint64, uint64, error) {
 }
 
 func (inst *KsymParser) Resolve(buildID string, addr uint64) string {
-	filePath := dbgsym.NewBuildID(dbgsym.KernelMode, "", inst.dbgDirPath).GetKernelPath(buildID)
-	sym := ""
-	inst.iterator(filePath, func(_addr uint64, symbolType, symbol string) error {
-		if _addr > addr {
-			return ErrFound
+	index, err := inst.GetIndex(buildID)
+	if err != nil {
+		return ""
+	}
+	return index.Resolve(addr)
+}
+
+// ResolveModule returns the closest symbol of the module below the address.
+func (inst *KsymParser) ResolveModule(buildID, module string, addr uint64) string {
+	index, err := inst.GetIndex(buildID)
+	if err != nil {
+		return ""
+	}
+	return index.ResolveModule(module, addr)
+}
+
+// KernelModule is the address range of a loaded module.
+type KernelModule struct {
+	Name  string
+	Start uint64
+	End   uint64
+}
+
+// LoadKernelModules reads the modules from a snapshot of /proc/modules, the
+// lines are "name size refcount deps state addr".
+func LoadKernelModules(filePath string) ([]KernelModule, error) {
+	fp, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var modules []KernelModule
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
 		}
-		sym = symbol
-		return nil
-	})
-	return sym
+		size, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		start, err := strconv.ParseUint(strings.TrimPrefix(fields[5], "0x"), 16, 64)
+		// the addresses are zero without the permission
+		if err != nil || start == 0 {
+			continue
+		}
+		modules = append(modules, KernelModule{
+			Name:  fields[0],
+			Start: start,
+			End:   start + size,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return modules, nil
 }
 
+// IsKernSymFile returns whether the file is the kallsyms snapshot of a run
+// or the build ID of the snapshot.
+func IsKernSymFile(filePath string) bool {
+	return strings.HasSuffix(filePath, ".kern.sym") || strings.HasSuffix(filePath, ".kern.sym"+dbgsym.KernelBuildIDPostfix)
+}
+
+// KernelSymPrepare imports the kallsyms snapshot of a run into the debug
+// directory and returns the ID of the kallsyms of the parsers.
 func KernelSymPrepare(dbgDirPath, kernSymPath string) (string, error) {
 	buildID := dbgsym.NewBuildID(dbgsym.KernelMode, "", dbgDirPath)
 	_buildID := ""
 	if _, err := os.Stat(kernSymPath); err == nil {
-		_kernSymPath, err := filepath.EvalSymlinks(kernSymPath)
+		kernBuildID, err := dbgsym.GetKernelBuildID(kernSymPath)
 		if err != nil {
 			return "", err
 		}
-
-		_buildID = dbgsym.GetBuildIDByPath(_kernSymPath)
-		dbgFile := buildID.GetKernelPath(_buildID)
-		if err := common.CopyFile(_kernSymPath, dbgFile); err != nil {
+		if _buildID, err = buildID.ImportKernel(kernBuildID, kernSymPath); err != nil {
 			return "", err
 		}
 	} else {
@@ -0,0 +1,162 @@
+package symbol
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	benchBuildID    = "bench"
+	benchSymbols    = 200000
+	benchModuleSyms = 20000
+	benchKernelBase = 0xffffffff81000000
+	benchModuleBase = 0xffffffffc0000000
+)
+
+// writeBenchKallsyms writes a kallsyms of the size of a distribution kernel
+// with a module.
+func writeBenchKallsyms(b *testing.B) string {
+	dbgDirPath := b.TempDir()
+	filePath := filepath.Join(dbgDirPath, benchBuildID, "kallsyms")
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		b.Fatal(err)
+	}
+	fp, err := os.Create(filePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer fp.Close()
+
+	writer := bufio.NewWriter(fp)
+	for i := 0; i < benchSymbols; i++ {
+		fmt.Fprintf(writer, "%016x T func_%d\n", benchKernelBase+uint64(i)*0x100, i)
+	}
+	for i := 0; i < benchModuleSyms; i++ {
+		fmt.Fprintf(writer, "%016x t mod_func_%d\t[mod]\n", benchModuleBase+uint64(i)*0x100, i)
+	}
+	if err := writer.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	return dbgDirPath
+}
+
+func benchAddrs() []uint64 {
+	addrs := make([]uint64, 1024)
+	for i := range addrs {
+		addrs[i] = benchKernelBase + uint64(rand.Intn(benchSymbols*0x100))
+	}
+	return addrs
+}
+
+// BenchmarkKsymParserScan is the lookup by scanning the file, as it was
+// done before the index.
+func BenchmarkKsymParserScan(b *testing.B) {
+	dbgDirPath := writeBenchKallsyms(b)
+	ksymParser := NewKsymParser(dbgDirPath)
+	filePath := filepath.Join(dbgDirPath, benchBuildID, "kallsyms")
+	addrs := benchAddrs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		addr := addrs[i%len(addrs)]
+		sym := ""
+		ksymParser.iterator(filePath, func(_addr uint64, symbolType, symbol, module string) error {
+			if _addr > addr {
+				return ErrFound
+			}
+			sym = symbol
+			return nil
+		})
+		if sym == "" {
+			b.Fatalf("Failed to resolve [0x%x]", addr)
+		}
+	}
+}
+
+func BenchmarkKsymParserResolve(b *testing.B) {
+	dbgDirPath := writeBenchKallsyms(b)
+	ksymParser := NewKsymParser(dbgDirPath)
+	addrs := benchAddrs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		addr := addrs[i%len(addrs)]
+		if ksymParser.Resolve(benchBuildID, addr) == "" {
+			b.Fatalf("Failed to resolve [0x%x]", addr)
+		}
+	}
+}
+
+func BenchmarkKsymParserResolveModule(b *testing.B) {
+	dbgDirPath := writeBenchKallsyms(b)
+	ksymParser := NewKsymParser(dbgDirPath)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		addr := benchModuleBase + uint64(rand.Intn(benchModuleSyms*0x100))
+		if ksymParser.ResolveModule(benchBuildID, "mod", addr) == "" {
+			b.Fatalf("Failed to resolve [0x%x]", addr)
+		}
+	}
+}
+
+func TestKsymParserResolve(t *testing.T) {
+	const buildID = "test"
+	dbgDirPath := t.TempDir()
+	filePath := filepath.Join(dbgDirPath, buildID, "kallsyms")
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	kallsyms := "ffffffff81000100 T func_a\n" +
+		"ffffffff81000000 T _text\n" +
+		"ffffffff81000200 t func_b\n" +
+		"ffffffff81001000 B _end\n" +
+		"ffffffffc0000100 t mod_func_b\t[mod]\n" +
+		"ffffffffc0000000 t mod_func_a\t[mod]\n"
+	if err := os.WriteFile(filePath, []byte(kallsyms), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ksymParser := NewKsymParser(dbgDirPath)
+
+	for _, test := range []struct {
+		addr uint64
+		name string
+	}{
+		{0xffffffff80ffffff, ""},
+		{0xffffffff81000000, "_text"},
+		{0xffffffff810000ff, "_text"},
+		{0xffffffff81000100, "func_a"},
+		{0xffffffff810001ff, "func_a"},
+		{0xffffffff81000200, "func_b"},
+		{0xffffffff81000fff, "func_b"},
+		{0xffffffff81001000, ""},
+		{0xffffffffbfffffff, ""},
+		{0xffffffffc0000000, "mod_func_a"},
+		{0xffffffffc0000100, "mod_func_b"},
+		{0xffffffffc0100000, "mod_func_b"},
+	} {
+		if name := ksymParser.Resolve(buildID, test.addr); name != test.name {
+			t.Errorf("Resolve [0x%x] = [%s], expected [%s]", test.addr, name, test.name)
+		}
+	}
+
+	for _, test := range []struct {
+		module string
+		addr   uint64
+		name   string
+	}{
+		{"mod", 0xffffffffbfffffff, ""},
+		{"mod", 0xffffffffc0000000, "mod_func_a"},
+		{"mod", 0xffffffffc00000ff, "mod_func_a"},
+		{"mod", 0xffffffffc0000100, "mod_func_b"},
+		{"other", 0xffffffffc0000100, ""},
+	} {
+		if name := ksymParser.ResolveModule(buildID, test.module, test.addr); name != test.name {
+			t.Errorf("ResolveModule [%s] [0x%x] = [%s], expected [%s]", test.module, test.addr, name, test.name)
+		}
+	}
+}
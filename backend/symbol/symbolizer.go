@@ -1,9 +1,13 @@
 package symbol
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/golang/groupcache/lru"
+	"github.com/ianlancetaylor/demangle"
 )
 
 type CpuMode int
@@ -17,41 +21,111 @@ const (
 
 const LRUCacheSize = 128
 
+// DbgDir is the debug directory in the home directory shared by the parsers
+// of all the profiles, the files are named by their build IDs.
+const DbgDir = ".hermes.dbg"
+
+func GetDbgDirPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, DbgDir), nil
+}
+
 type Records map[uint64]string
 
 type Symbolizer struct {
-	mutex      *sync.RWMutex
-	cache      *lru.Cache
-	ksymParser *KsymParser
+	mutex       *sync.RWMutex
+	cache       *lru.Cache
+	ksymParser  *KsymParser
+	elfParser   *ElfSymParser
+	dwarfParser *DwarfParser
+	rawNames    bool
 }
 
-func NewSymbolizer(dbgDirPath string) *Symbolizer {
+// NewSymbolizer demangles the C++ and Rust symbols unless rawNames is set.
+func NewSymbolizer(dbgDirPath string, rawNames bool) *Symbolizer {
 	return &Symbolizer{
-		mutex:      &sync.RWMutex{},
-		cache:      lru.New(LRUCacheSize),
-		ksymParser: NewKsymParser(dbgDirPath),
+		rawNames:    rawNames,
+		mutex:       &sync.RWMutex{},
+		cache:       lru.New(LRUCacheSize),
+		ksymParser:  NewKsymParser(dbgDirPath),
+		elfParser:   NewElfSymParser(dbgDirPath),
+		dwarfParser: NewDwarfParser(dbgDirPath),
 	}
 }
 
+// Symbolize resolves a kernel address, or a file offset of the user-space
+// ELF identified by the build ID.
 func (inst *Symbolizer) Symbolize(cpuMode CpuMode, buildID string, addr uint64) (string, error) {
-	symbol := ""
-	inst.mutex.RLock()
-	if recs, ok := inst.cache.Get(buildID); ok {
-		if rec, exist := recs.(Records)[addr]; exist {
-			symbol = rec
-		}
-	}
-	inst.mutex.RUnlock()
-
-	if symbol != "" {
+	if symbol := inst.getCache(buildID, addr); symbol != "" {
 		return symbol, nil
 	}
 
+	symbol := ""
 	switch cpuMode {
 	case KernelMode:
 		symbol = inst.ksymParser.Resolve(buildID, addr)
+	case UserMode:
+		symbol = inst.elfParser.Resolve(buildID, addr)
+	}
+	// Rust in the kernel is mangled as well
+	symbol = inst.demangle(symbol)
+
+	inst.addCache(buildID, addr, symbol)
+	if symbol == "" {
+		return "", fmt.Errorf("Failed to symbolize [0x%x] of build ID [%s]", addr, buildID)
+	}
+	return symbol, nil
+}
+
+// SymbolizePath resolves a file offset of the user-space ELF at the path,
+// for the files mapped without a build ID.
+func (inst *Symbolizer) SymbolizePath(filePath string, addr uint64) (string, error) {
+	if symbol := inst.getCache(filePath, addr); symbol != "" {
+		return symbol, nil
+	}
+
+	symbol := inst.demangle(inst.elfParser.ResolvePath(filePath, addr))
+
+	inst.addCache(filePath, addr, symbol)
+	if symbol == "" {
+		return "", fmt.Errorf("Failed to symbolize [0x%x] of [%s]", addr, filePath)
+	}
+	return symbol, nil
+}
+
+// SymbolizeModule resolves an address of a kernel module as "func [module]".
+func (inst *Symbolizer) SymbolizeModule(buildID, module string, addr uint64) (string, error) {
+	if symbol := inst.getCache(buildID, addr); symbol != "" {
+		return symbol, nil
+	}
+
+	symbol := inst.demangle(inst.ksymParser.ResolveModule(buildID, module, addr))
+	if symbol != "" {
+		symbol = fmt.Sprintf("%s [%s]", symbol, module)
+	}
+
+	inst.addCache(buildID, addr, symbol)
+	if symbol == "" {
+		return "", fmt.Errorf("Failed to symbolize [0x%x] of module [%s]", addr, module)
+	}
+	return symbol, nil
+}
+
+func (inst *Symbolizer) getCache(buildID string, addr uint64) string {
+	inst.mutex.RLock()
+	defer inst.mutex.RUnlock()
+	if recs, ok := inst.cache.Get(buildID); ok {
+		if rec, exist := recs.(Records)[addr]; exist {
+			return rec
+		}
 	}
+	return ""
+}
 
+func (inst *Symbolizer) addCache(buildID string, addr uint64, symbol string) {
 	inst.mutex.Lock()
 	defer inst.mutex.Unlock()
 	recs := Records{}
@@ -61,5 +135,38 @@ func (inst *Symbolizer) Symbolize(cpuMode CpuMode, buildID string, addr uint64)
 	recs[addr] = symbol
 	inst.cache.Remove(buildID)
 	inst.cache.Add(buildID, recs)
-	return symbol, nil
+}
+
+// demangle returns the Itanium C++ and Rust symbols readable, the others
+// are returned as they are.
+func (inst *Symbolizer) demangle(name string) string {
+	if inst.rawNames || name == "" {
+		return name
+	}
+	return demangle.Filter(name)
+}
+
+// SymbolizeFrames expands a user-space address into its inlined frames with
+// the source lines, it falls back to the function alone without DWARF.
+func (inst *Symbolizer) SymbolizeFrames(cpuMode CpuMode, buildID string, addr uint64) ([]Frame, error) {
+	if cpuMode == UserMode {
+		if elfSymbols, err := inst.elfParser.GetSymbols(buildID); err == nil {
+			if vaddr, ok := elfSymbols.GetAddr(addr); ok {
+				if frames, err := inst.dwarfParser.Resolve(buildID, vaddr); err == nil {
+					_frames := make([]Frame, len(frames))
+					for i, frame := range frames {
+						_frames[i] = frame
+						_frames[i].Function = inst.demangle(frame.Function)
+					}
+					return _frames, nil
+				}
+			}
+		}
+	}
+
+	symbol, err := inst.Symbolize(cpuMode, buildID, addr)
+	if err != nil {
+		return nil, err
+	}
+	return []Frame{{Function: symbol}}, nil
 }
@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+type FlameGraphFormat string
+
+const (
+	// FoldedFormat is the folded stacks of flamegraph.pl and inferno, one
+	// "root;...;leaf value" line per stack
+	FoldedFormat FlameGraphFormat = "folded"
+	// SpeedscopeFormat is the sampled profile of speedscope.app
+	SpeedscopeFormat FlameGraphFormat = "speedscope"
+)
+
+const (
+	FlameGraphPostfix = ".stack.json"
+	FoldedPostfix     = ".folded"
+	SpeedscopePostfix = ".speedscope.json"
+	SpeedscopeSchema  = "https://www.speedscope.app/file-format-schema.json"
+)
+
+// ParseFlameGraphFormats parses the comma-separated formats to export
+// besides the flame graph JSON.
+func ParseFlameGraphFormats(formats string) ([]FlameGraphFormat, error) {
+	ret := []FlameGraphFormat{}
+	for _, format := range strings.Split(formats, ",") {
+		format = strings.TrimSpace(format)
+		if format == "" {
+			continue
+		}
+		switch FlameGraphFormat(format) {
+		case FoldedFormat, SpeedscopeFormat:
+			ret = append(ret, FlameGraphFormat(format))
+		default:
+			return nil, fmt.Errorf("Unrecognized flame graph format [%s]", format)
+		}
+	}
+	return ret, nil
+}
+
+// GetFlameGraphExportPath returns the path of the format exported next to
+// the flame graph JSON of the path.
+func GetFlameGraphExportPath(path string, format FlameGraphFormat) string {
+	path = strings.TrimSuffix(path, FlameGraphPostfix)
+	if format == SpeedscopeFormat {
+		return path + SpeedscopePostfix
+	}
+	return path + FoldedPostfix
+}
+
+// LoadFlameGraphData reads back the flame graph JSON of WriteToFile.
+func LoadFlameGraphData(path string) (*FlameGraphData, error) {
+	type node struct {
+		Name     string `json:"name"`
+		Value    int64  `json:"value"`
+		Children []node `json:"children"`
+	}
+	var root node
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &root); err != nil {
+		return nil, err
+	}
+
+	var convert func(*node) *FlameGraphData
+	convert = func(_node *node) *FlameGraphData {
+		data := &FlameGraphData{
+			Name:     _node.Name,
+			Value:    _node.Value,
+			Children: make(map[string]*FlameGraphData),
+		}
+		for i := range _node.Children {
+			child := &_node.Children[i]
+			// the zero children written ahead of the others
+			if child.Name == "" && child.Value == 0 {
+				continue
+			}
+			data.Children[child.Name] = convert(child)
+		}
+		return data
+	}
+	return convert(&root), nil
+}
+
+// walk visits the stacks from the root in the order of the names with the
+// values of their own, i.e. without the values of the children.
+func (data *FlameGraphData) walk(stack []*FlameGraphData, visit func(stack []*FlameGraphData, val int64)) {
+	names := make([]string, 0, len(data.Children))
+	val := data.Value
+	for name, child := range data.Children {
+		names = append(names, name)
+		val -= child.Value
+	}
+	if val > 0 && len(stack) != 0 {
+		visit(stack, val)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		child := data.Children[name]
+		child.walk(append(stack, child), visit)
+	}
+}
+
+func (data *FlameGraphData) WriteFolded(writer io.Writer) error {
+	var err error
+	bufWriter := bufio.NewWriter(writer)
+	data.walk(nil, func(stack []*FlameGraphData, val int64) {
+		names := make([]string, len(stack))
+		for i, frame := range stack {
+			names[i] = frame.Name
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(bufWriter, "%s %d\n", strings.Join(names, ";"), val)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return bufWriter.Flush()
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+}
+
+type speedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"`
+	Weights    []int64 `json:"weights"`
+}
+
+// MarshalSpeedscope returns a sampled profile of the unit of speedscope,
+// e.g. none for the samples, microseconds or bytes.
+func (data *FlameGraphData) MarshalSpeedscope(name, unit string) ([]byte, error) {
+	frames := []speedscopeFrame{}
+	frameIdxes := map[string]int{}
+	profile := speedscopeProfile{
+		Type:       "sampled",
+		Name:       name,
+		Unit:       unit,
+		StartValue: 0,
+		EndValue:   data.Value,
+		Samples:    [][]int{},
+		Weights:    []int64{},
+	}
+	data.walk(nil, func(stack []*FlameGraphData, val int64) {
+		sample := make([]int, len(stack))
+		for i, frame := range stack {
+			idx, isExist := frameIdxes[frame.Name]
+			if !isExist {
+				idx = len(frames)
+				frames = append(frames, speedscopeFrame{Name: frame.Name})
+				frameIdxes[frame.Name] = idx
+			}
+			sample[i] = idx
+		}
+		profile.Samples = append(profile.Samples, sample)
+		profile.Weights = append(profile.Weights, val)
+	})
+
+	return json.Marshal(&struct {
+		Schema   string              `json:"$schema"`
+		Shared   map[string]any      `json:"shared"`
+		Profiles []speedscopeProfile `json:"profiles"`
+		Name     string              `json:"name"`
+		Exporter string              `json:"exporter"`
+	}{
+		Schema:   SpeedscopeSchema,
+		Shared:   map[string]any{"frames": frames},
+		Profiles: []speedscopeProfile{profile},
+		Name:     name,
+		Exporter: "hermes",
+	})
+}
+
+// Export writes the formats next to the flame graph JSON of the path, the
+// name and the unit are those of the speedscope profile.
+func (data *FlameGraphData) Export(path string, formats []FlameGraphFormat, name, unit string) error {
+	for _, format := range formats {
+		fp, err := os.OpenFile(GetFlameGraphExportPath(path, format), os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		if format == SpeedscopeFormat {
+			var bytes []byte
+			if bytes, err = data.MarshalSpeedscope(name, unit); err == nil {
+				_, err = fp.Write(bytes)
+			}
+		} else {
+			err = data.WriteFolded(fp)
+		}
+		fp.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	KmsgEntry      = "/dev/kmsg"
+	kmsgRecordSize = 8192
+)
+
+type KmsgRecord struct {
+	Priority  int    `json:"priority"`
+	Seq       uint64 `json:"seq"`
+	Timestamp uint64 `json:"timestamp"` // microseconds since boot
+	Message   string `json:"message"`
+}
+
+// parseKmsgRecord parses "prio,seq,usec,flags;message" followed by the
+// optional continuation lines.
+func parseKmsgRecord(data string) (*KmsgRecord, bool) {
+	header, body, found := strings.Cut(data, ";")
+	if !found {
+		return nil, false
+	}
+	fields := strings.Split(header, ",")
+	if len(fields) < 3 {
+		return nil, false
+	}
+
+	var record KmsgRecord
+	prio, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, false
+	}
+	record.Priority = prio & 7 // the facility is in the upper bits
+	if record.Seq, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return nil, false
+	}
+	if record.Timestamp, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return nil, false
+	}
+	record.Message, _, _ = strings.Cut(body, "\n")
+	return &record, true
+}
+
+// ReadKmsg returns the kernel log records of the last window without
+// blocking for new ones.
+func ReadKmsg(window time.Duration) ([]KmsgRecord, error) {
+	fd, err := unix.Open(KmsgEntry, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("open", err)
+	}
+	defer unix.Close(fd)
+
+	var now unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now); err != nil {
+		return nil, os.NewSyscallError("clock_gettime", err)
+	}
+	var since uint64
+	if usec := uint64(now.Nano() / int64(time.Microsecond)); usec > uint64(window.Microseconds()) {
+		since = usec - uint64(window.Microseconds())
+	}
+
+	var records []KmsgRecord
+	buf := make([]byte, kmsgRecordSize)
+	for {
+		n, err := unix.Read(fd, buf)
+		if err == unix.EAGAIN {
+			break
+		} else if err == unix.EPIPE {
+			// the record was overwritten while reading, skip to the next one
+			continue
+		} else if err != nil {
+			return nil, os.NewSyscallError("read", err)
+		}
+
+		record, ok := parseKmsgRecord(string(buf[:n]))
+		if !ok || record.Timestamp < since {
+			continue
+		}
+		records = append(records, *record)
+	}
+	return records, nil
+}
+
+// KmsgWatcher reads the kernel log records appended after it was created.
+type KmsgWatcher struct {
+	fd  int
+	buf []byte
+}
+
+func NewKmsgWatcher() (*KmsgWatcher, error) {
+	fd, err := unix.Open(KmsgEntry, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("open", err)
+	}
+	if _, err := unix.Seek(fd, 0, unix.SEEK_END); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("lseek", err)
+	}
+	return &KmsgWatcher{
+		fd:  fd,
+		buf: make([]byte, kmsgRecordSize),
+	}, nil
+}
+
+// Wait returns the new records, or none if there are no new records within
+// the timeout.
+func (watcher *KmsgWatcher) Wait(timeout time.Duration) ([]KmsgRecord, error) {
+	fds := []unix.PollFd{{Fd: int32(watcher.fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, int(timeout.Milliseconds()))
+	if err == unix.EINTR || (err == nil && n == 0) {
+		return nil, nil
+	} else if err != nil {
+		return nil, os.NewSyscallError("poll", err)
+	}
+
+	var records []KmsgRecord
+	for {
+		n, err := unix.Read(watcher.fd, watcher.buf)
+		if err == unix.EAGAIN {
+			break
+		} else if err == unix.EPIPE {
+			continue
+		} else if err != nil {
+			return nil, os.NewSyscallError("read", err)
+		}
+		if record, ok := parseKmsgRecord(string(watcher.buf[:n])); ok {
+			records = append(records, *record)
+		}
+	}
+	return records, nil
+}
+
+func (watcher *KmsgWatcher) Close() {
+	unix.Close(watcher.fd)
+}
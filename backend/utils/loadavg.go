@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	LoadAvgEntry  = "/proc/loadavg"
+	ProcStatEntry = "/proc/stat"
+	ProcsRunning  = "procs_running"
+	ProcsBlocked  = "procs_blocked"
+)
+
+type LoadAvg struct {
+	Load1        float64 `json:"load1"`
+	Load5        float64 `json:"load5"`
+	Load15       float64 `json:"load15"`
+	ProcsRunning float64 `json:"procs_running"`
+	ProcsBlocked float64 `json:"procs_blocked"`
+}
+
+func (loadAvg *LoadAvg) parseLoadAvg() error {
+	bytes, err := ioutil.ReadFile(LoadAvgEntry)
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(string(bytes))
+	if len(fields) < 3 {
+		return fmt.Errorf("Unexpected format of [%s]", LoadAvgEntry)
+	}
+	for i, val := range []*float64{&loadAvg.Load1, &loadAvg.Load5, &loadAvg.Load15} {
+		if *val, err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (loadAvg *LoadAvg) parseProcStat() error {
+	file, err := os.Open(ProcStatEntry)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		var val *float64
+		if fields[0] == ProcsRunning {
+			val = &loadAvg.ProcsRunning
+		} else if fields[0] == ProcsBlocked {
+			val = &loadAvg.ProcsBlocked
+		} else {
+			continue
+		}
+		if *val, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// GetLoadAvg returns the load averages of /proc/loadavg together with the
+// run queue of /proc/stat.
+func GetLoadAvg() (*LoadAvg, error) {
+	var loadAvg LoadAvg
+	if err := loadAvg.parseLoadAvg(); err != nil {
+		return nil, err
+	}
+	if err := loadAvg.parseProcStat(); err != nil {
+		return nil, err
+	}
+	return &loadAvg, nil
+}
+
+// PerCpu returns the values divided by the number of CPUs.
+func (loadAvg *LoadAvg) PerCpu(cpuNum int) *LoadAvg {
+	if cpuNum <= 0 {
+		cpuNum = 1
+	}
+	n := float64(cpuNum)
+	return &LoadAvg{
+		Load1:        loadAvg.Load1 / n,
+		Load5:        loadAvg.Load5 / n,
+		Load15:       loadAvg.Load15 / n,
+		ProcsRunning: loadAvg.ProcsRunning / n,
+		ProcsBlocked: loadAvg.ProcsBlocked / n,
+	}
+}
+
+func (loadAvg *LoadAvg) GetEntry(entry string) float64 {
+	switch entry {
+	case "load1":
+		return loadAvg.Load1
+	case "load5":
+		return loadAvg.Load5
+	case "load15":
+		return loadAvg.Load15
+	case ProcsRunning:
+		return loadAvg.ProcsRunning
+	case ProcsBlocked:
+		return loadAvg.ProcsBlocked
+	}
+	return 0 // unknown loadavg entry
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// PprofMapping is a mapped file of a process, the addresses of the
+// locations in it are the ips.
+type PprofMapping struct {
+	Start   uint64
+	Limit   uint64
+	Offset  uint64
+	File    string
+	BuildID string
+}
+
+// PprofFrame is a function of a location, the inlined frames come first.
+type PprofFrame struct {
+	Function string
+	File     string
+	Line     int64
+}
+
+type pprofFunctionKey struct {
+	name string
+	file string
+}
+
+type pprofLocationKey struct {
+	mappingID uint64
+	addr      uint64
+	function  string
+}
+
+// PprofData builds a profile.proto of the samples with one value, the
+// mappings, functions and locations are shared by the samples.
+type PprofData struct {
+	profile   *profile.Profile
+	mappings  map[PprofMapping]*profile.Mapping
+	functions map[pprofFunctionKey]*profile.Function
+	locations map[pprofLocationKey]*profile.Location
+	samples   map[string]*profile.Sample
+}
+
+func NewPprofData(sampleType, unit string) *PprofData {
+	valueType := &profile.ValueType{
+		Type: sampleType,
+		Unit: unit,
+	}
+	return &PprofData{
+		profile: &profile.Profile{
+			SampleType: []*profile.ValueType{valueType},
+			PeriodType: valueType,
+			Period:     1,
+		},
+		mappings:  map[PprofMapping]*profile.Mapping{},
+		functions: map[pprofFunctionKey]*profile.Function{},
+		locations: map[pprofLocationKey]*profile.Location{},
+		samples:   map[string]*profile.Sample{},
+	}
+}
+
+// SetTime sets when the profile was collected in unix seconds.
+func (data *PprofData) SetTime(timestamp int64) {
+	data.profile.TimeNanos = timestamp * 1e9
+}
+
+func (data *PprofData) AddMapping(mapping PprofMapping) *profile.Mapping {
+	if _mapping, isExist := data.mappings[mapping]; isExist {
+		return _mapping
+	}
+	_mapping := &profile.Mapping{
+		ID:      uint64(len(data.profile.Mapping) + 1),
+		Start:   mapping.Start,
+		Limit:   mapping.Limit,
+		Offset:  mapping.Offset,
+		File:    mapping.File,
+		BuildID: mapping.BuildID,
+	}
+	data.profile.Mapping = append(data.profile.Mapping, _mapping)
+	data.mappings[mapping] = _mapping
+	return _mapping
+}
+
+func (data *PprofData) addFunction(name, file string) *profile.Function {
+	key := pprofFunctionKey{name: name, file: file}
+	if function, isExist := data.functions[key]; isExist {
+		return function
+	}
+	function := &profile.Function{
+		ID:         uint64(len(data.profile.Function) + 1),
+		Name:       name,
+		SystemName: name,
+		Filename:   file,
+	}
+	data.profile.Function = append(data.profile.Function, function)
+	data.functions[key] = function
+	return function
+}
+
+// AddLocation returns the location of the address in the mapping, which is
+// nil when the address isn't mapped. The location has no line when the
+// address isn't symbolized.
+func (data *PprofData) AddLocation(mapping *profile.Mapping, addr uint64, frames []PprofFrame) *profile.Location {
+	key := pprofLocationKey{addr: addr}
+	if mapping != nil {
+		key.mappingID = mapping.ID
+	}
+	if len(frames) != 0 {
+		// the same address of JIT code differs among processes
+		key.function = frames[0].Function
+	}
+	if location, isExist := data.locations[key]; isExist {
+		return location
+	}
+
+	location := &profile.Location{
+		ID:      uint64(len(data.profile.Location) + 1),
+		Mapping: mapping,
+		Address: addr,
+	}
+	for _, frame := range frames {
+		location.Line = append(location.Line, profile.Line{
+			Function: data.addFunction(frame.Function, frame.File),
+			Line:     frame.Line,
+		})
+	}
+	if mapping != nil && len(frames) != 0 {
+		mapping.HasFunctions = true
+		mapping.HasFilenames = mapping.HasFilenames || frames[0].File != ""
+		mapping.HasLineNumbers = mapping.HasLineNumbers || frames[0].Line != 0
+		mapping.HasInlineFrames = mapping.HasInlineFrames || len(frames) > 1
+	}
+	data.profile.Location = append(data.profile.Location, location)
+	data.locations[key] = location
+	return location
+}
+
+func (data *PprofData) getSampleKey(locations []*profile.Location, labels map[string]string, numLabels map[string]int64) string {
+	var builder strings.Builder
+	for _, location := range locations {
+		fmt.Fprintf(&builder, "%d,", location.ID)
+	}
+	keys := []string{}
+	for key, val := range labels {
+		keys = append(keys, fmt.Sprintf("%s=%s", key, val))
+	}
+	for key, val := range numLabels {
+		keys = append(keys, fmt.Sprintf("%s#%d", key, val))
+	}
+	sort.Strings(keys)
+	builder.WriteString(strings.Join(keys, ","))
+	return builder.String()
+}
+
+// AddSample adds the value to the stack of the locations from the leaf,
+// the samples of the same stack and labels are merged.
+func (data *PprofData) AddSample(locations []*profile.Location, val int64, labels map[string]string, numLabels map[string]int64) {
+	key := data.getSampleKey(locations, labels, numLabels)
+	if sample, isExist := data.samples[key]; isExist {
+		sample.Value[0] += val
+		return
+	}
+
+	sample := &profile.Sample{
+		Location: locations,
+		Value:    []int64{val},
+		Label:    map[string][]string{},
+		NumLabel: map[string][]int64{},
+	}
+	for key, val := range labels {
+		sample.Label[key] = []string{val}
+	}
+	for key, val := range numLabels {
+		sample.NumLabel[key] = []int64{val}
+	}
+	data.profile.Sample = append(data.profile.Sample, sample)
+	data.samples[key] = sample
+}
+
+// WriteToFile writes the gzipped profile.proto read by go tool pprof.
+func (data *PprofData) WriteToFile(path string) error {
+	if err := data.profile.CheckValid(); err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(path, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return data.profile.Write(fp)
+}
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+const (
+	CgroupRoot      = "/sys/fs/cgroup"
+	CgroupProcs     = "cgroup.procs"
+	PressurePostfix = ".pressure"
+)
+
+type ProcessSample struct {
+	Pid        int32   `json:"pid"`
+	Comm       string  `json:"comm"`
+	CpuPercent float64 `json:"cpu_percent"`
+	RssMB      uint64  `json:"rss_mb"`
+	Threads    int32   `json:"threads"`
+	Fds        int32   `json:"fds"`
+	cpuTime    float64
+}
+
+// FindPidsByComm returns the processes whose comm matches the expression.
+func FindPidsByComm(expr string) ([]int32, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	pids, err := process.Pids()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []int32
+	for _, pid := range pids {
+		comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+		if err != nil {
+			continue
+		}
+		if re.MatchString(strings.TrimSpace(string(comm))) {
+			ret = append(ret, pid)
+		}
+	}
+	return ret, nil
+}
+
+func ReadPidFile(path string) (int32, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(bytes)), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Unexpected pid file [%s] content, err [%s]", path, err)
+	}
+	return int32(pid), nil
+}
+
+// GetUnitCgroup returns the cgroup v2 directory of a systemd unit.
+func GetUnitCgroup(ctx context.Context, unit string) (string, error) {
+	out, err := exec.CommandContext(ctx, "systemctl", "show", "-p", "ControlGroup", "--value", unit).Output()
+	if err != nil {
+		return "", err
+	}
+	cgroup := strings.TrimSpace(string(out))
+	if cgroup == "" {
+		return "", fmt.Errorf("Unit [%s] has no control group", unit)
+	}
+	return filepath.Join(CgroupRoot, cgroup), nil
+}
+
+// GetCgroupPath accepts either an absolute path under the cgroup v2 mount
+// or a path relative to it.
+func GetCgroupPath(cgroup string) string {
+	if strings.HasPrefix(cgroup, CgroupRoot) {
+		return filepath.Clean(cgroup)
+	}
+	return filepath.Join(CgroupRoot, cgroup)
+}
+
+func GetCgroupPids(cgroupPath string) ([]int32, error) {
+	file, err := os.Open(filepath.Join(cgroupPath, CgroupProcs))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var pids []int32
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		pid, err := strconv.ParseInt(strings.TrimSpace(scanner.Text()), 10, 32)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, int32(pid))
+	}
+	return pids, scanner.Err()
+}
+
+func (psi *PSI) GetCgroupLevel(cgroupPath string, psiType PSIType) (*PSIResult, error) {
+	return psi.getResult(filepath.Join(cgroupPath, string(psiType)+PressurePostfix))
+}
+
+// GetCgroupTreePids returns the processes of the cgroup and its descendants.
+func GetCgroupTreePids(cgroupPath string) ([]int32, error) {
+	var pids []int32
+	err := filepath.WalkDir(cgroupPath, func(path string, entry os.DirEntry, err error) error {
+		// the child cgroups may be removed while walking
+		if err != nil {
+			if path != cgroupPath && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		_pids, err := GetCgroupPids(path)
+		if err != nil {
+			if path != cgroupPath && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		pids = append(pids, _pids...)
+		return nil
+	})
+	return pids, err
+}
+
+// GetTids returns the threads of a process.
+func GetTids(pid int32) ([]int32, error) {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	tids := make([]int32, 0, len(entries))
+	for _, entry := range entries {
+		tid, err := strconv.ParseInt(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		tids = append(tids, int32(tid))
+	}
+	return tids, nil
+}
+
+// GetNsPid returns the pid of a process in its innermost pid namespace.
+func GetNsPid(pid int32) (int32, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "NSpid:" {
+			continue
+		}
+		nsPid, err := strconv.ParseInt(fields[len(fields)-1], 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		return int32(nsPid), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return pid, nil
+}
+
+// NewProcessSample reads the current state of a process, the cpu percent
+// is only available after Update.
+func NewProcessSample(pid int32) (*ProcessSample, error) {
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	sample := &ProcessSample{Pid: pid}
+	if sample.Comm, err = proc.Name(); err != nil {
+		return nil, err
+	}
+	times, err := proc.Times()
+	if err != nil {
+		return nil, err
+	}
+	sample.cpuTime = times.User + times.System
+	if memInfo, err := proc.MemoryInfo(); err == nil {
+		sample.RssMB = memInfo.RSS >> 20
+	}
+	if sample.Threads, err = proc.NumThreads(); err != nil {
+		return nil, err
+	}
+	// fds of other users cannot always be read, keep the sample anyway
+	sample.Fds, _ = proc.NumFDs()
+	return sample, nil
+}
+
+// Update resamples the process and computes its cpu percent over elapsed
+// seconds.
+func (sample *ProcessSample) Update(elapsed float64) error {
+	next, err := NewProcessSample(sample.Pid)
+	if err != nil {
+		return err
+	}
+	if elapsed > 0 {
+		next.CpuPercent = (next.cpuTime - sample.cpuTime) / elapsed * 100
+	}
+	*sample = *next
+	return nil
+}
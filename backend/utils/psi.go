@@ -2,11 +2,14 @@ package utils
 
 import (
 	"bufio"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -104,3 +107,49 @@ func (psi *PSI) getResult(path string) (*PSIResult, error) {
 func (psi *PSI) GetSystemLevel(psiType PSIType) (*PSIResult, error) {
 	return psi.getResult(systemLevelPath + string(psiType))
 }
+
+func GetSystemPressurePath(psiType PSIType) string {
+	return systemLevelPath + string(psiType)
+}
+
+// PSITrigger is a kernel PSI trigger, it fires when the stall time within
+// the window exceeds the threshold, both in microseconds.
+type PSITrigger struct {
+	fd int
+}
+
+func NewPSITrigger(path, stall string, threshold, window uint32) (*PSITrigger, error) {
+	fd, err := unix.Open(path, unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("open", err)
+	}
+
+	trigger := fmt.Sprintf("%s %d %d", stall, threshold, window)
+	if _, err := unix.Write(fd, append([]byte(trigger), 0)); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("Failed to set trigger [%s] on [%s], err [%s]", trigger, path, err)
+	}
+	return &PSITrigger{fd: fd}, nil
+}
+
+// Wait returns true if the trigger fired within the timeout.
+func (trigger *PSITrigger) Wait(timeout time.Duration) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(trigger.fd), Events: unix.POLLPRI}}
+	n, err := unix.Poll(fds, int(timeout.Milliseconds()))
+	if err == unix.EINTR {
+		return false, nil
+	} else if err != nil {
+		return false, os.NewSyscallError("poll", err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	if fds[0].Revents&unix.POLLERR != 0 {
+		return false, fmt.Errorf("PSI trigger is no longer available")
+	}
+	return fds[0].Revents&unix.POLLPRI != 0, nil
+}
+
+func (trigger *PSITrigger) Close() {
+	unix.Close(trigger.fd)
+}
@@ -7,6 +7,9 @@ import (
 	"os"
 	"os/signal"
 
+	"hermes/backend/dbgsym"
+	"hermes/backend/symbol"
+	"hermes/backend/utils"
 	"hermes/collector"
 	"hermes/common"
 	"hermes/log"
@@ -26,6 +29,14 @@ var (
 	storEngine   string
 	viewDir      string
 	instantParse bool
+
+	maxHeavyJobs        uint
+	maxProfilingSeconds uint
+	maxCpuPercent       uint
+	rawSymbols          bool
+	flameGraphFormats   string
+	lineDetail          bool
+	fetchDebugInfo      bool
 )
 
 func init() {
@@ -39,6 +50,13 @@ func init() {
 	flag.StringVar(&storEngine, "storage_engine", "file", "The storage engine")
 	flag.StringVar(&viewDir, "view_dir", homeDir+common.ViewDirDefault, "The path of view directory")
 	flag.BoolVar(&instantParse, "instant_parse", true, "Instant parse")
+	flag.UintVar(&maxHeavyJobs, "max_heavy_jobs", 0, "The max number of concurrent heavy jobs, 0 means unlimited")
+	flag.UintVar(&maxProfilingSeconds, "max_profiling_seconds", 0, "The max seconds of heavy jobs per hour, 0 means unlimited")
+	flag.UintVar(&maxCpuPercent, "max_cpu_percent", 0, "The max cpu percent of collector, 0 means unlimited")
+	flag.BoolVar(&rawSymbols, "raw_symbols", false, "Keep the mangled C++ and Rust symbols")
+	flag.StringVar(&flameGraphFormats, "flamegraph_formats", "", "The flame graph formats to export besides JSON (folded,speedscope)")
+	flag.BoolVar(&lineDetail, "line_detail", false, "Write the CPU flame graph of the inlined frames and source lines as well")
+	flag.BoolVar(&fetchDebugInfo, "fetch_debuginfo", false, "Download the debuginfo of the binaries from the debuginfod servers, on if DEBUGINFOD_URLS is set")
 	flag.Usage = usage
 }
 
@@ -47,13 +65,13 @@ func usage() {
 	flag.PrintDefaults()
 }
 
-func logParseRoutine(ctx context.Context, jobCompleteSub chan log.LogMetaPubFormat) {
+func logParseRoutine(ctx context.Context, jobCompleteSub chan log.LogMetaPubFormat, parserOptions parser.Options) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case logMetaPub := <-jobCompleteSub:
-			parser, err := parser.NewParser(logDir, viewDir, logMetaPub.Timestamp, logMetaPub.LogMetadata)
+			parser, err := parser.NewParser(logDir, viewDir, logMetaPub.Timestamp, logMetaPub.LogMetadata, parserOptions)
 			if err != nil {
 				logrus.Errorf("Failed to generate parser for timestamp [%d], err [%s]", logMetaPub.Timestamp, err)
 				continue
@@ -78,13 +96,29 @@ func main() {
 		logrus.Fatal(err)
 	}
 
+	symbol.SetFetchDebugInfo(fetchDebugInfo || os.Getenv(dbgsym.DebugInfodURLsEnv) != "")
+	formats, err := utils.ParseFlameGraphFormats(flameGraphFormats)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	parserOptions := parser.Options{
+		RawSymbols:        rawSymbols,
+		FlameGraphFormats: formats,
+		LineDetail:        lineDetail,
+	}
+
 	var jobCompleteSub chan log.LogMetaPubFormat
 	if instantParse {
 		jobCompleteSub = make(chan log.LogMetaPubFormat, JobCompleteChanSize)
-		go logParseRoutine(ctx, jobCompleteSub)
+		go logParseRoutine(ctx, jobCompleteSub, parserOptions)
 	}
 
-	jobQueue, err := collector.NewJobQueue(configDir, logDir, storEngine, jobCompleteSub)
+	budget := collector.Budget{
+		MaxHeavyJobs:        uint32(maxHeavyJobs),
+		MaxProfilingSeconds: uint32(maxProfilingSeconds),
+		MaxCpuPercent:       uint32(maxCpuPercent),
+	}
+	jobQueue, err := collector.NewJobQueue(configDir, logDir, storEngine, budget, jobCompleteSub)
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -6,6 +6,9 @@ import (
 	"os"
 	"sort"
 
+	"hermes/backend/dbgsym"
+	"hermes/backend/symbol"
+	"hermes/backend/utils"
 	"hermes/common"
 	"hermes/parser"
 	"hermes/storage"
@@ -19,6 +22,11 @@ var (
 	outputDir   string
 	storEngine  string
 	mode        string
+	rawSymbols  bool
+
+	flameGraphFormats string
+	lineDetail        bool
+	fetchDebugInfo    bool
 )
 
 func init() {
@@ -31,6 +39,10 @@ func init() {
 	flag.StringVar(&outputDir, "output_dir", homeDir+common.ViewDirDefault, "The path of view directory")
 	flag.StringVar(&storEngine, "storage_engine", "file", "The storage engine (file)")
 	flag.StringVar(&mode, "mode", "oneshot", "Mode (oneshot|daemon)")
+	flag.BoolVar(&rawSymbols, "raw_symbols", false, "Keep the mangled C++ and Rust symbols")
+	flag.StringVar(&flameGraphFormats, "flamegraph_formats", "", "The flame graph formats to export besides JSON (folded,speedscope)")
+	flag.BoolVar(&lineDetail, "line_detail", false, "Write the CPU flame graph of the inlined frames and source lines as well")
+	flag.BoolVar(&fetchDebugInfo, "fetch_debuginfo", false, "Download the debuginfo of the binaries from the debuginfod servers, on if DEBUGINFOD_URLS is set")
 	flag.Usage = usage
 }
 
@@ -61,10 +73,20 @@ func main() {
 	for timestamp := range logMetas {
 		timestamps = append(timestamps, timestamp)
 	}
+	symbol.SetFetchDebugInfo(fetchDebugInfo || os.Getenv(dbgsym.DebugInfodURLsEnv) != "")
+	formats, err := utils.ParseFlameGraphFormats(flameGraphFormats)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	parserOptions := parser.Options{
+		RawSymbols:        rawSymbols,
+		FlameGraphFormats: formats,
+		LineDetail:        lineDetail,
+	}
 	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
 	for _, timestamp := range timestamps {
 		for _, logMeta := range logMetas[timestamp] {
-			parser, err := parser.NewParser(logDir, outputDir, timestamp, logMeta)
+			parser, err := parser.NewParser(logDir, outputDir, timestamp, logMeta, parserOptions)
 			if err != nil {
 				logrus.Errorf("Failed to generate parser for timestamp [%d], err [%s]", timestamp, err)
 				continue
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"hermes/backend/utils"
 	"hermes/common"
 	"net/http"
 	"os"
@@ -14,6 +16,20 @@ import (
 
 var contentParser *ContentParser
 
+// getCpuProfilePath returns the flame graph of the CPU profile of the
+// request and its name. detail=line expands the inlined frames with the
+// source lines, it is parsed with -line_detail.
+func getCpuProfilePath(ctx *gin.Context) (string, string) {
+	timestamp := ctx.Param("timestamp")
+	fileName := "overall_cpu.stack.json"
+	name := "cpu_profile_" + timestamp
+	if ctx.Query("detail") == "line" {
+		fileName = "overall_cpu.line.stack.json"
+		name += ".line"
+	}
+	return filepath.Join(viewDir, "cpu_profile", timestamp, fileName), name
+}
+
 var (
 	metadataDir string
 	frontendDir string
@@ -36,6 +52,48 @@ func usage() {
 	flag.PrintDefaults()
 }
 
+// serveFlameGraph serves the flame graph JSON of the path, or downloads it
+// as folded stacks or speedscope with format=folded|speedscope. The exports
+// of the parser are served if any, otherwise they are converted here.
+func serveFlameGraph(ctx *gin.Context, path, name, unit string) {
+	format := utils.FlameGraphFormat(ctx.Query("format"))
+	if format != utils.FoldedFormat && format != utils.SpeedscopeFormat {
+		ctx.File(path)
+		return
+	}
+
+	exportPath := utils.GetFlameGraphExportPath(path, format)
+	fileName := utils.GetFlameGraphExportPath(name, format)
+	if _, err := os.Stat(exportPath); err == nil {
+		ctx.FileAttachment(exportPath, fileName)
+		return
+	}
+	flameGraphData, err := utils.LoadFlameGraphData(path)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{})
+		return
+	}
+
+	var buf bytes.Buffer
+	contentType := "text/plain; charset=utf-8"
+	if format == utils.SpeedscopeFormat {
+		contentType = "application/json"
+		var data []byte
+		if data, err = flameGraphData.MarshalSpeedscope(name, unit); err == nil {
+			buf.Write(data)
+		}
+	} else {
+		err = flameGraphData.WriteFolded(&buf)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to convert [%s] to [%s], err [%s]", path, format, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{})
+		return
+	}
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	ctx.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
 func main() {
 	router := gin.Default()
 
@@ -58,6 +116,18 @@ func main() {
 		api.GET("/routines", func(ctx *gin.Context) {
 			ctx.ProtoBuf(http.StatusOK, contentParser.GetRoutines())
 		})
+		api.GET("/runs/:job", func(ctx *gin.Context) {
+			path := filepath.Join(viewDir, ctx.Param("job"), "runs")
+			ctx.File(path)
+		})
+		api.GET("/loadavg/:routine", func(ctx *gin.Context) {
+			path := filepath.Join(viewDir, ctx.Param("routine"), "loadavg_overview")
+			ctx.File(path)
+		})
+		api.GET("/kmsg/:routine", func(ctx *gin.Context) {
+			path := filepath.Join(viewDir, ctx.Param("routine"), "kmsg_overview")
+			ctx.File(path)
+		})
 	}
 
 	cpu := router.Group("/cpu")
@@ -68,8 +138,28 @@ func main() {
 		})
 		cpu.GET("/cpu_profile/:timestamp", func(ctx *gin.Context) {
 			timestamp := ctx.Param("timestamp")
-			path := filepath.Join(viewDir, "cpu_profile", timestamp, "overall_cpu.stack.json")
-			ctx.File(path)
+			// format=pprof downloads the profile.proto for go tool pprof
+			if ctx.Query("format") == "pprof" {
+				path := filepath.Join(viewDir, "cpu_profile", timestamp, "overall_cpu.pb.gz")
+				ctx.FileAttachment(path, "cpu_profile_"+timestamp+".pb.gz")
+				return
+			}
+			path, name := getCpuProfilePath(ctx)
+			serveFlameGraph(ctx, path, name, "none")
+		})
+		// tells whether the flame graph of the detail is parsed
+		cpu.HEAD("/cpu_profile/:timestamp", func(ctx *gin.Context) {
+			path, _ := getCpuProfilePath(ctx)
+			if _, err := os.Stat(path); err != nil {
+				ctx.Status(http.StatusNotFound)
+				return
+			}
+			ctx.Status(http.StatusOK)
+		})
+		cpu.GET("/offcpu_profile/:timestamp", func(ctx *gin.Context) {
+			timestamp := ctx.Param("timestamp")
+			path := filepath.Join(viewDir, "offcpu_profile", timestamp, "offcpu.stack.json")
+			serveFlameGraph(ctx, path, "offcpu_profile_"+timestamp, "microseconds")
 		})
 	}
 
@@ -81,8 +171,13 @@ func main() {
 		})
 		mem.GET("/memleak_profile/:timestamp", func(ctx *gin.Context) {
 			timestamp := ctx.Param("timestamp")
+			if ctx.Query("format") == "pprof" {
+				path := filepath.Join(viewDir, "memleak_profile", timestamp, "slab.pb.gz")
+				ctx.FileAttachment(path, "slab_profile_"+timestamp+".pb.gz")
+				return
+			}
 			path := filepath.Join(viewDir, "memleak_profile", timestamp, "slab.stack.json")
-			ctx.File(path)
+			serveFlameGraph(ctx, path, "slab_profile_"+timestamp, "bytes")
 		})
 	}
 
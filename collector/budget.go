@@ -0,0 +1,202 @@
+package collector
+
+import (
+	"syscall"
+	"time"
+
+	"hermes/common"
+	"hermes/log"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	budgetWindow         = time.Hour
+	budgetCpuSampleDelay = time.Second
+)
+
+// Budget limits the overhead of the collector as a whole. A zero value
+// means unlimited. MaxCpuPercent is relative to a single CPU and covers the
+// collector together with the tools it spawns.
+type Budget struct {
+	MaxHeavyJobs        uint32
+	MaxProfilingSeconds uint32
+	MaxCpuPercent       uint32
+}
+
+type budgetUsage struct {
+	end     time.Time
+	elapsed time.Duration
+}
+
+type budgetKeeper struct {
+	budget Budget
+	heavy  map[string]bool
+	// running holds the slots of the admitted heavy jobs by the time their
+	// content tasks started, zero while their conditions are evaluated
+	running  map[string]time.Time
+	usages   []budgetUsage
+	deferred []string
+
+	lastSample  time.Time
+	lastCpuTime time.Duration
+	cpuPercent  float64
+}
+
+func newBudgetKeeper(budget Budget) *budgetKeeper {
+	return &budgetKeeper{
+		budget:  budget,
+		heavy:   make(map[string]bool),
+		running: make(map[string]time.Time)}
+}
+
+func isHeavyJob(configDir string, job *Job) bool {
+	for name, routine := range job.Routines {
+		task, err := NewTask(configDir, routine)
+		if err != nil {
+			logrus.Warnf("Failed to load routine [%s] of job [%s], err [%s]", name, job.Name, err)
+			continue
+		}
+		if common.Contains(HeavyTaskTypes, task.Task.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func (keeper *budgetKeeper) addJob(configDir string, job *Job) {
+	keeper.heavy[job.Name] = isHeavyJob(configDir, job)
+}
+
+func (keeper *budgetKeeper) removeJob(jobName string) {
+	delete(keeper.heavy, jobName)
+	for i, name := range keeper.deferred {
+		if name == jobName {
+			keeper.deferred = append(keeper.deferred[:i], keeper.deferred[i+1:]...)
+			break
+		}
+	}
+}
+
+func getCpuTime() (time.Duration, error) {
+	var cpuTime time.Duration
+	for _, who := range []int{syscall.RUSAGE_SELF, syscall.RUSAGE_CHILDREN} {
+		var usage syscall.Rusage
+		if err := syscall.Getrusage(who, &usage); err != nil {
+			return 0, err
+		}
+		cpuTime += time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	}
+	return cpuTime, nil
+}
+
+func (keeper *budgetKeeper) getCpuPercent(now time.Time) float64 {
+	if now.Sub(keeper.lastSample) < budgetCpuSampleDelay {
+		return keeper.cpuPercent
+	}
+
+	cpuTime, err := getCpuTime()
+	if err != nil {
+		logrus.Warnf("Failed to get cpu usage of collector, err [%s]", err)
+		return keeper.cpuPercent
+	}
+	if !keeper.lastSample.IsZero() {
+		keeper.cpuPercent = float64(cpuTime-keeper.lastCpuTime) / float64(now.Sub(keeper.lastSample)) * 100
+	}
+	keeper.lastSample = now
+	keeper.lastCpuTime = cpuTime
+	return keeper.cpuPercent
+}
+
+func (keeper *budgetKeeper) getProfilingTime(now time.Time) time.Duration {
+	var total time.Duration
+	usages := keeper.usages[:0]
+	for _, usage := range keeper.usages {
+		if now.Sub(usage.end) > budgetWindow {
+			continue
+		}
+		usages = append(usages, usage)
+		total += usage.elapsed
+	}
+	keeper.usages = usages
+
+	for _, start := range keeper.running {
+		if !start.IsZero() {
+			total += now.Sub(start)
+		}
+	}
+	return total
+}
+
+// admit returns an empty status if the job is allowed to run now, or the
+// status to be recorded for the run otherwise.
+func (keeper *budgetKeeper) admit(jobName string) string {
+	now := time.Now()
+	if keeper.budget.MaxCpuPercent != 0 && keeper.getCpuPercent(now) > float64(keeper.budget.MaxCpuPercent) {
+		logrus.Warnf("Job [%s] is skipped, cpu usage of collector [%.1f%%] exceeds budget [%d%%]",
+			jobName, keeper.cpuPercent, keeper.budget.MaxCpuPercent)
+		return log.JobSkipped
+	}
+
+	if !keeper.heavy[jobName] {
+		return ""
+	}
+	// a heavy job that is still running is handled by its overlap policy
+	if _, isExist := keeper.running[jobName]; isExist {
+		return ""
+	}
+
+	if keeper.budget.MaxProfilingSeconds != 0 &&
+		keeper.getProfilingTime(now) >= time.Duration(keeper.budget.MaxProfilingSeconds)*time.Second {
+		logrus.Warnf("Job [%s] is skipped, profiling time in the last hour exceeds budget [%ds]",
+			jobName, keeper.budget.MaxProfilingSeconds)
+		return log.JobSkipped
+	}
+
+	if keeper.budget.MaxHeavyJobs != 0 && len(keeper.running) >= int(keeper.budget.MaxHeavyJobs) {
+		if common.Contains(keeper.deferred, jobName) {
+			return log.JobCoalesced
+		}
+		logrus.Infof("Job [%s] is deferred, [%d] heavy jobs are running", jobName, len(keeper.running))
+		keeper.deferred = append(keeper.deferred, jobName)
+		return log.JobDeferred
+	}
+
+	keeper.running[jobName] = time.Time{}
+	return ""
+}
+
+// start accounts the profiling time of an admitted job from now on, the
+// time of its conditions isn't.
+func (keeper *budgetKeeper) start(jobName string) {
+	if start, isExist := keeper.running[jobName]; isExist && start.IsZero() {
+		keeper.running[jobName] = time.Now()
+	}
+}
+
+// release accounts a finished run and returns the deferred job to be
+// retried, if any. A run whose conditions failed frees its slot without
+// using the profiling time.
+func (keeper *budgetKeeper) release(result JobResult) string {
+	start, isExist := keeper.running[result.JobName]
+	if !isExist {
+		return ""
+	}
+
+	if !start.IsZero() {
+		now := time.Now()
+		keeper.usages = append(keeper.usages, budgetUsage{end: now, elapsed: now.Sub(start)})
+	}
+	if result.Continued {
+		keeper.running[result.JobName] = time.Time{}
+		return ""
+	}
+	delete(keeper.running, result.JobName)
+
+	if len(keeper.deferred) == 0 {
+		return ""
+	}
+	jobName := keeper.deferred[0]
+	keeper.deferred = keeper.deferred[1:]
+	return jobName
+}
@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"hermes/log"
+)
+
+func newTestBudgetKeeper(budget Budget, heavyJobs ...string) *budgetKeeper {
+	keeper := newBudgetKeeper(budget)
+	for _, jobName := range heavyJobs {
+		keeper.heavy[jobName] = true
+	}
+	keeper.heavy["light"] = false
+	return keeper
+}
+
+func TestBudgetKeeperAdmit(t *testing.T) {
+	keeper := newTestBudgetKeeper(Budget{MaxHeavyJobs: 1}, "a", "b")
+
+	for _, test := range []struct {
+		jobName string
+		status  string
+	}{
+		{"a", ""},
+		// a running heavy job is left to its overlap policy
+		{"a", ""},
+		{"light", ""},
+		{"b", log.JobDeferred},
+		{"b", log.JobCoalesced},
+	} {
+		if status := keeper.admit(test.jobName); status != test.status {
+			t.Fatalf("Admit [%s] = [%s], expected [%s]", test.jobName, status, test.status)
+		}
+	}
+
+	if jobName := keeper.release(JobResult{JobName: "a"}); jobName != "b" {
+		t.Fatalf("Release [a] retries [%s], expected [b]", jobName)
+	}
+	if status := keeper.admit("b"); status != "" {
+		t.Fatalf("Admit [b] = [%s] after release", status)
+	}
+	if jobName := keeper.release(JobResult{JobName: "b"}); jobName != "" {
+		t.Fatalf("Release [b] retries [%s], expected none", jobName)
+	}
+	if len(keeper.running) != 0 || len(keeper.deferred) != 0 {
+		t.Fatalf("Unexpected running [%v] and deferred [%v] jobs", keeper.running, keeper.deferred)
+	}
+}
+
+func TestBudgetKeeperRelease(t *testing.T) {
+	keeper := newTestBudgetKeeper(Budget{MaxHeavyJobs: 1}, "a", "b")
+	keeper.admit("a")
+
+	// the slot is kept while a pending run continues
+	if jobName := keeper.release(JobResult{JobName: "a", Continued: true}); jobName != "" {
+		t.Fatalf("Release of continued [a] retries [%s]", jobName)
+	}
+	if status := keeper.admit("b"); status != log.JobDeferred {
+		t.Fatalf("Admit [b] = [%s] while [a] continues, expected [%s]", status, log.JobDeferred)
+	}
+	// the result of a job that isn't running is ignored
+	if jobName := keeper.release(JobResult{JobName: "light"}); jobName != "" {
+		t.Fatalf("Release [light] retries [%s]", jobName)
+	}
+	if jobName := keeper.release(JobResult{JobName: "a"}); jobName != "b" {
+		t.Fatalf("Release [a] retries [%s], expected [b]", jobName)
+	}
+}
+
+func TestBudgetKeeperProfilingTime(t *testing.T) {
+	keeper := newTestBudgetKeeper(Budget{MaxProfilingSeconds: 60}, "a")
+	later := time.Now().Add(time.Hour / 2)
+
+	// the conditions of the job don't use the budget
+	keeper.admit("a")
+	if profilingTime := keeper.getProfilingTime(later); profilingTime != 0 {
+		t.Fatalf("Unexpected profiling time [%s] before the content tasks start", profilingTime)
+	}
+	keeper.release(JobResult{JobName: "a"})
+	if len(keeper.usages) != 0 {
+		t.Fatalf("Unexpected usages [%v] of a run without content tasks", keeper.usages)
+	}
+
+	keeper.admit("a")
+	keeper.start("a")
+	if profilingTime := keeper.getProfilingTime(later); profilingTime < time.Minute {
+		t.Fatalf("Unexpected profiling time [%s] of a running job", profilingTime)
+	}
+	// a job started in the past uses up the budget
+	keeper.running["a"] = time.Now().Add(-2 * time.Minute)
+	keeper.release(JobResult{JobName: "a"})
+	if status := keeper.admit("a"); status != log.JobSkipped {
+		t.Fatalf("Admit [a] = [%s] beyond the budget, expected [%s]", status, log.JobSkipped)
+	}
+	// the usages expire after the window
+	if profilingTime := keeper.getProfilingTime(time.Now().Add(budgetWindow + time.Minute)); profilingTime != 0 {
+		t.Fatalf("Unexpected profiling time [%s] after the window", profilingTime)
+	}
+}
@@ -0,0 +1,254 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"hermes/log"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	CondAll = "all"
+	CondAny = "any"
+	CondNot = "not"
+)
+
+// NotTriggeredError is returned by a condition task which ran but didn't
+// trigger, the other errors are failures to run it.
+type NotTriggeredError struct {
+	Reason string
+}
+
+func (err *NotTriggeredError) Error() string {
+	return err.Reason
+}
+
+func notTriggered(format string, args ...interface{}) error {
+	return &NotTriggeredError{Reason: fmt.Sprintf(format, args...)}
+}
+
+func IsNotTriggered(err error) bool {
+	var notTriggeredErr *NotTriggeredError
+	return errors.As(err, &notTriggeredErr)
+}
+
+// CondExpr is a boolean expression over condition tasks. A leaf holds a
+// single condition task, the other nodes combine their children with
+// all (AND), any (OR) or not (NOT):
+//
+//	condition:
+//	  all:
+//	    - cpu_info:
+//	        threshold: 80
+//	    - not:
+//	        psi: null
+type CondExpr struct {
+	Op       string
+	Leaf     TaskContext
+	Children []*CondExpr
+
+	label     string
+	triggered bool
+}
+
+func toCondMap(item interface{}) (map[string]interface{}, error) {
+	switch item := item.(type) {
+	case map[string]interface{}:
+		return item, nil
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(item))
+		for key, val := range item {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("Unexpected condition key [%v]", key)
+			}
+			ret[name] = val
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("Unexpected condition format [%v]", item)
+}
+
+func newCondExpr(configDir string, cond map[string]interface{}) (*CondExpr, error) {
+	if len(cond) != 1 {
+		return nil, fmt.Errorf("Condition expects exactly one entry, got [%d]", len(cond))
+	}
+
+	var expr CondExpr
+	for key, val := range cond {
+		switch key {
+		case CondAll, CondAny:
+			items, ok := val.([]interface{})
+			if !ok || len(items) == 0 {
+				return nil, fmt.Errorf("Condition [%s] expects a non-empty list", key)
+			}
+			for _, item := range items {
+				child, err := newCondItem(configDir, item)
+				if err != nil {
+					return nil, err
+				}
+				expr.Children = append(expr.Children, child)
+			}
+		case CondNot:
+			child, err := newCondItem(configDir, val)
+			if err != nil {
+				return nil, err
+			}
+			expr.Children = append(expr.Children, child)
+		default:
+			if err := loadTask(configDir, key, val, &expr.Leaf); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		expr.Op = key
+	}
+	return &expr, nil
+}
+
+func newCondItem(configDir string, item interface{}) (*CondExpr, error) {
+	cond, err := toCondMap(item)
+	if err != nil {
+		return nil, err
+	}
+	return newCondExpr(configDir, cond)
+}
+
+// leaves returns the condition tasks in the order they appear in the config
+// and assigns each of them a distinct log data label.
+func (expr *CondExpr) leaves() []*CondExpr {
+	var ret []*CondExpr
+	var walk func(node *CondExpr)
+	walk = func(node *CondExpr) {
+		if node.Op == "" {
+			ret = append(ret, node)
+			return
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(expr)
+
+	// a plain condition keeps the label it always had
+	if len(ret) == 1 {
+		ret[0].label = ".cond"
+	} else {
+		for i, leaf := range ret {
+			leaf.label = ".cond" + strconv.Itoa(i)
+		}
+	}
+	return ret
+}
+
+func (expr *CondExpr) eval() bool {
+	switch expr.Op {
+	case CondAll:
+		for _, child := range expr.Children {
+			if !child.eval() {
+				return false
+			}
+		}
+		return true
+	case CondAny:
+		for _, child := range expr.Children {
+			if child.eval() {
+				return true
+			}
+		}
+		return false
+	case CondNot:
+		return !expr.Children[0].eval()
+	}
+	return expr.triggered
+}
+
+// evaluate runs every condition task concurrently, so that each sub-result
+// is recorded even if it does not change the outcome. The samples of the
+// tasks supporting trigger options are fed into their state in scope. A
+// NotTriggeredError tells the expression isn't satisfied, including when a
+// task fails to run, so that the routine takes cond_fail as it always has.
+func (expr *CondExpr) evaluate(ctx context.Context, task *Task, logDir, logDataLabel string, scope *TriggerScope) error {
+	leaves := expr.leaves()
+	errChans := make([]chan error, len(leaves))
+	for i, leaf := range leaves {
+		errChans[i] = make(chan error, 1)
+		if cursorContext, ok := leaf.Leaf.Context.(CursorContext); ok && scope != nil {
+			scope.loadCursor(leaf.label, cursorContext)
+		}
+		go task.execute(ctx, &leaf.Leaf, logDir, logDataLabel+leaf.label, errChans[i])
+	}
+
+	var leafErr, execErr error
+	for i, leaf := range leaves {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errChans[i]:
+			leaf.triggered = err == nil
+			if err != nil && !IsNotTriggered(err) {
+				if execErr == nil {
+					execErr = err
+				}
+				continue
+			}
+			if cursorContext, ok := leaf.Leaf.Context.(CursorContext); ok && scope != nil {
+				scope.saveCursor(leaf.label, cursorContext)
+			}
+			if triggerContext, ok := leaf.Leaf.Context.(TriggerContext); ok && scope != nil {
+				leaf.triggered = scope.update(leaf.label, triggerContext)
+				if !leaf.triggered && err == nil {
+					err = notTriggered("Condition is held back by trigger options")
+				}
+			}
+			if err != nil && leafErr == nil {
+				leafErr = err
+			}
+		}
+	}
+
+	// a failed task doesn't trigger the expression as a whole, as not would
+	// invert it
+	if execErr != nil {
+		logrus.Errorf("Failed to run condition task, err [%s]", execErr)
+		return notTriggered("Condition task failed, err [%s]", execErr)
+	}
+	if expr.eval() {
+		return nil
+	}
+	if len(leaves) == 1 && leafErr != nil {
+		return leafErr
+	}
+	return notTriggered("Condition [%s] is not satisfied", expr.Op)
+}
+
+func (expr *CondExpr) metadatas(task *Task) []log.Metadata {
+	var ret []log.Metadata
+	for _, leaf := range expr.leaves() {
+		postfix := "*"
+		if instance, err := task.getInstance(leaf.Leaf.Type); err == nil {
+			postfix = leaf.label + instance.GetLogDataPathPostfix(leaf.Leaf.Context)
+		}
+		triggered := leaf.triggered
+		ret = append(ret, log.Metadata{
+			TaskType:       int(leaf.Leaf.Type),
+			LogDataPostfix: postfix,
+			Triggered:      &triggered,
+		})
+	}
+	return ret
+}
+
+func (expr *CondExpr) targets() *Targets {
+	var ret Targets
+	for _, leaf := range expr.leaves() {
+		if targetContext, ok := leaf.Leaf.Context.(TargetContext); ok && leaf.triggered {
+			ret.merge(targetContext.GetTargets())
+		}
+	}
+	return &ret
+}
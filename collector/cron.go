@@ -0,0 +1,189 @@
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cronSearchYears = 5
+
+type cronField struct {
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	cronMinute = cronField{min: 0, max: 59}
+	cronHour   = cronField{min: 0, max: 23}
+	cronDom    = cronField{min: 1, max: 31}
+	cronMonth  = cronField{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronField{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// CronExpr is a standard 5-field cron expression (minute hour day-of-month
+// month day-of-week). Like cron(8), when both day fields are restricted a
+// time matches if either of them matches.
+type CronExpr struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	anyDom bool
+	anyDow bool
+}
+
+func (field *cronField) parseValue(token string) (int, error) {
+	if val, isExist := field.names[strings.ToLower(token)]; isExist {
+		return val, nil
+	}
+	val, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("Unexpected cron value [%s]", token)
+	}
+	if val < field.min || val > field.max {
+		return 0, fmt.Errorf("Cron value [%d] is out of range [%d-%d]", val, field.min, field.max)
+	}
+	return val, nil
+}
+
+func (field *cronField) parseRange(token string) (uint64, error) {
+	var bits uint64
+	rangeToken, stepToken, hasStep := strings.Cut(token, "/")
+	step := 1
+	if hasStep {
+		val, err := strconv.Atoi(stepToken)
+		if err != nil || val <= 0 {
+			return 0, fmt.Errorf("Unexpected cron step [%s]", stepToken)
+		}
+		step = val
+	}
+
+	start, end := field.min, field.max
+	if rangeToken != "*" {
+		lhs, rhs, isRange := strings.Cut(rangeToken, "-")
+		val, err := field.parseValue(lhs)
+		if err != nil {
+			return 0, err
+		}
+		start = val
+		if isRange {
+			if end, err = field.parseValue(rhs); err != nil {
+				return 0, err
+			}
+		} else if !hasStep {
+			end = start
+		}
+	}
+	if start > end {
+		return 0, fmt.Errorf("Unexpected cron range [%s]", rangeToken)
+	}
+
+	for val := start; val <= end; val += step {
+		bits |= 1 << uint(val)
+	}
+	return bits, nil
+}
+
+func (field *cronField) parse(token string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(token, ",") {
+		val, err := field.parseRange(part)
+		if err != nil {
+			return 0, err
+		}
+		bits |= val
+	}
+	return bits, nil
+}
+
+func ParseCronExpr(expr string) (*CronExpr, error) {
+	if macro, isExist := cronMacros[strings.TrimSpace(expr)]; isExist {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Unexpected cron expression [%s], expect 5 fields", expr)
+	}
+
+	var cronExpr CronExpr
+	var err error
+	if cronExpr.minute, err = cronMinute.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if cronExpr.hour, err = cronHour.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if cronExpr.dom, err = cronDom.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if cronExpr.month, err = cronMonth.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if cronExpr.dow, err = cronDow.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	// both 0 and 7 stand for Sunday
+	if cronExpr.dow&(1<<7) != 0 {
+		cronExpr.dow |= 1
+	}
+	cronExpr.anyDom = strings.HasPrefix(fields[2], "*")
+	cronExpr.anyDow = strings.HasPrefix(fields[4], "*")
+	return &cronExpr, nil
+}
+
+func (expr *CronExpr) matchDay(t time.Time) bool {
+	domMatch := expr.dom&(1<<uint(t.Day())) != 0
+	dowMatch := expr.dow&(1<<uint(t.Weekday())) != 0
+	if expr.anyDom || expr.anyDow {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time strictly after t that matches the expression,
+// or the zero time if there is none within the search horizon.
+func (expr *CronExpr) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if expr.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !expr.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if expr.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if expr.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
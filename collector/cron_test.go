@@ -0,0 +1,117 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"hermes/storage"
+)
+
+func TestParseCronExprErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@never",
+	} {
+		if _, err := ParseCronExpr(expr); err == nil {
+			t.Errorf("ParseCronExpr [%s] is accepted", expr)
+		}
+	}
+}
+
+func TestCronExprNext(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	for _, test := range []struct {
+		expr     string
+		from     time.Time
+		expected time.Time
+	}{
+		// steps
+		{"*/15 * * * *", time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC), date(2024, 1, 1, 10, 15)},
+		{"*/15 * * * *", date(2024, 1, 1, 10, 45), date(2024, 1, 1, 11, 0)},
+		{"0 */6 * * *", date(2024, 1, 1, 19, 0), date(2024, 1, 2, 0, 0)},
+		// ranges with steps and lists
+		{"10-20/5 8 * * *", date(2024, 1, 1, 8, 12), date(2024, 1, 1, 8, 15)},
+		{"10-20/5 8 * * *", date(2024, 1, 1, 8, 20), date(2024, 1, 2, 8, 10)},
+		{"5/20 * * * *", date(2024, 1, 1, 0, 30), date(2024, 1, 1, 0, 45)},
+		{"0 1,13 * * *", date(2024, 1, 1, 2, 0), date(2024, 1, 1, 13, 0)},
+		// bounds
+		{"59 23 * * *", date(2024, 1, 1, 23, 58), date(2024, 1, 1, 23, 59)},
+		{"59 23 31 12 *", date(2024, 12, 31, 23, 59), date(2025, 12, 31, 23, 59)},
+		{"0 0 1 1 *", date(2024, 12, 31, 23, 59), date(2025, 1, 1, 0, 0)},
+		// names and both Sunday values, 2024-06-01 is a Saturday
+		{"0 0 * jan,jul *", date(2024, 2, 1, 0, 0), date(2024, 7, 1, 0, 0)},
+		{"0 9 * * mon-fri", date(2024, 6, 1, 10, 0), date(2024, 6, 3, 9, 0)},
+		{"0 0 * * 0", date(2024, 6, 1, 10, 0), date(2024, 6, 2, 0, 0)},
+		{"0 0 * * 7", date(2024, 6, 1, 10, 0), date(2024, 6, 2, 0, 0)},
+		{"0 0 * * SUN", date(2024, 6, 1, 10, 0), date(2024, 6, 2, 0, 0)},
+		// either day field matches when both are restricted, 2024-09-01 is a
+		// Sunday and 2024-09-13 a Friday
+		{"0 0 13 * fri", date(2024, 9, 1, 0, 0), date(2024, 9, 6, 0, 0)},
+		{"0 0 13 * fri", date(2024, 9, 7, 0, 0), date(2024, 9, 13, 0, 0)},
+		{"0 0 10 * mon", date(2024, 9, 3, 0, 0), date(2024, 9, 9, 0, 0)},
+		{"0 0 10 * mon", date(2024, 9, 9, 0, 0), date(2024, 9, 10, 0, 0)},
+		{"0 0 * * fri", date(2024, 9, 1, 0, 0), date(2024, 9, 6, 0, 0)},
+		{"0 0 13 * *", date(2024, 9, 1, 0, 0), date(2024, 9, 13, 0, 0)},
+		// month ends
+		{"0 0 1 * *", date(2024, 1, 31, 12, 0), date(2024, 2, 1, 0, 0)},
+		{"0 0 31 * *", date(2024, 4, 1, 0, 0), date(2024, 5, 31, 0, 0)},
+		{"0 0 29 2 *", date(2023, 3, 1, 0, 0), date(2024, 2, 29, 0, 0)},
+		{"30 12 * * *", date(2024, 2, 29, 13, 0), date(2024, 3, 1, 12, 30)},
+		{"0 0 30 2 *", date(2024, 1, 1, 0, 0), time.Time{}},
+		// macros
+		{"@hourly", date(2024, 1, 1, 10, 0), date(2024, 1, 1, 11, 0)},
+		{"@weekly", date(2024, 6, 1, 10, 0), date(2024, 6, 2, 0, 0)},
+		{"@monthly", date(2024, 12, 15, 0, 0), date(2025, 1, 1, 0, 0)},
+	} {
+		cronExpr, err := ParseCronExpr(test.expr)
+		if err != nil {
+			t.Errorf("Failed to parse [%s], err [%s]", test.expr, err)
+			continue
+		}
+		if next := cronExpr.Next(test.from); !next.Equal(test.expected) {
+			t.Errorf("Next [%s] of [%s] = [%s], expected [%s]", test.expr, test.from, next, test.expected)
+		}
+	}
+}
+
+// TestJobTickerRemoveJob checks that a job is removed once its schedule has
+// ended, e.g. on quit.
+func TestJobTickerRemoveJob(t *testing.T) {
+	history, err := storage.GetFileHistoryStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobTicker, err := NewJobTicker(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobTicker.AddJob(Job{Name: "job", Class: Periodic, Interval: 3600})
+	jobTicker.quit <- true
+
+	done := make(chan struct{})
+	go func() {
+		jobTicker.RemoveJob("job")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RemoveJob blocks on an ended schedule")
+	}
+}
@@ -3,12 +3,15 @@ package collector
 import (
 	"context"
 	"fmt"
+	"hermes/common"
 	"hermes/log"
+	"hermes/storage"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
@@ -17,10 +20,18 @@ import (
 const (
 	Disposable = "disposable"
 	Periodic   = "periodic"
+	Cron       = "cron"
+	Event      = "event"
 	Enabled    = "enabled"
 	Disabled   = "disabled"
 )
 
+const (
+	OverlapSkip   = "skip"
+	OverlapQueue  = "queue"
+	OverlapCancel = "cancel"
+)
+
 type Routine struct {
 	Cond     map[string]interface{} `yaml:"condition"`
 	Task     map[string]interface{} `yaml:"content"`
@@ -28,14 +39,69 @@ type Routine struct {
 	CondFail string                 `yaml:"cond_fail"`
 }
 
+const ScheduleTimeLayout = "2006-01-02 15:04:05"
+
+type Schedule struct {
+	Cron   string `yaml:"cron,omitempty"`
+	Start  string `yaml:"start,omitempty"`
+	End    string `yaml:"end,omitempty"`
+	Jitter uint32 `yaml:"jitter,omitempty"`
+
+	cronExpr  *CronExpr
+	startTime time.Time
+	endTime   time.Time
+}
+
+func parseScheduleTime(val string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{ScheduleTimeLayout, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, val, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Unexpected schedule time format [%s]", val)
+}
+
+func (schedule *Schedule) check(class string) error {
+	var err error
+	if schedule.Cron != "" {
+		if schedule.cronExpr, err = ParseCronExpr(schedule.Cron); err != nil {
+			return err
+		}
+	} else if class == Cron {
+		return fmt.Errorf("The cron expression cannot be empty for class [%s]", Cron)
+	}
+
+	if schedule.Start != "" {
+		if schedule.startTime, err = parseScheduleTime(schedule.Start); err != nil {
+			return err
+		}
+	}
+	if schedule.End != "" {
+		if schedule.endTime, err = parseScheduleTime(schedule.End); err != nil {
+			return err
+		}
+	}
+	if !schedule.startTime.IsZero() && !schedule.endTime.IsZero() && !schedule.startTime.Before(schedule.endTime) {
+		return fmt.Errorf("The schedule start [%s] is not before end [%s]", schedule.Start, schedule.End)
+	}
+	return nil
+}
+
 type Job struct {
-	Name       string             `yaml:"-"`
-	Class      string             `yaml:"class"`
-	Interval   uint32             `yaml:"interval"`
-	Status     string             `yaml:"status"`
-	AptInstall []string           `yaml:"apt_install"`
-	Routines   map[string]Routine `yaml:"routines"`
-	Start      string             `yaml:"start"`
+	Name        string             `yaml:"-"`
+	Class       string             `yaml:"class"`
+	Interval    uint32             `yaml:"interval"`
+	Schedule    Schedule           `yaml:"schedule,omitempty"`
+	Triggers    []Trigger          `yaml:"triggers,omitempty"`
+	MaxDuration uint32             `yaml:"max_duration,omitempty"`
+	Overlap     string             `yaml:"overlap,omitempty"`
+	Status      string             `yaml:"status"`
+	AptInstall  []string           `yaml:"apt_install"`
+	Routines    map[string]Routine `yaml:"routines"`
+	Start       string             `yaml:"start"`
 }
 
 func getFileNameWithoutExt(configPath string) string {
@@ -74,6 +140,27 @@ func NewJob(configPath string) (*Job, error) {
 		}
 	}
 
+	if len(job.Overlap) == 0 {
+		job.Overlap = OverlapSkip
+	} else if !common.Contains([]string{OverlapSkip, OverlapQueue, OverlapCancel}, job.Overlap) {
+		return nil, fmt.Errorf("Unrecognized overlap policy [%s]", job.Overlap)
+	}
+
+	if job.Class == Periodic && job.Interval == 0 {
+		return nil, fmt.Errorf("The interval cannot be zero for class [%s]", Periodic)
+	}
+	if err := job.Schedule.check(job.Class); err != nil {
+		return nil, err
+	}
+	if job.Class == Event && len(job.Triggers) == 0 {
+		return nil, fmt.Errorf("The triggers cannot be empty for class [%s]", Event)
+	}
+	for i := range job.Triggers {
+		if err := job.Triggers[i].check(); err != nil {
+			return nil, err
+		}
+	}
+
 	return &job, nil
 }
 
@@ -86,18 +173,31 @@ type JobQueueComm struct {
 
 type JobQueue struct {
 	Comm      JobQueueComm
+	configDir string
 	jobProtos map[string]Job
 	ticker    *JobTicker
+	trigger   *JobTrigger
 	runner    *JobRunner
+	keeper    *budgetKeeper
 }
 
-func NewJobQueue(configDir, logDir, storEngine string, jobCompleteSub chan log.LogMetaPubFormat) (*JobQueue, error) {
-	ticker, err := NewJobTicker()
+func NewJobQueue(configDir, logDir, storEngine string, budget Budget, jobCompleteSub chan log.LogMetaPubFormat) (*JobQueue, error) {
+	history, err := storage.GetHistoryStore(storEngine, logDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker, err := NewJobTicker(history)
 	if err != nil {
 		return nil, err
 	}
 
-	runner, err := NewJobRunner(configDir, logDir, storEngine, jobCompleteSub)
+	trigger, err := NewJobTrigger()
+	if err != nil {
+		return nil, err
+	}
+
+	runner, err := NewJobRunner(configDir, logDir, storEngine, history, jobCompleteSub)
 	if err != nil {
 		return nil, err
 	}
@@ -108,9 +208,12 @@ func NewJobQueue(configDir, logDir, storEngine string, jobCompleteSub chan log.L
 			ModifyJob: make(chan Job),
 			RemoveJob: make(chan string),
 			Ack:       make(chan error)},
+		configDir: configDir,
 		jobProtos: make(map[string]Job),
 		ticker:    ticker,
-		runner:    runner}, nil
+		trigger:   trigger,
+		runner:    runner,
+		keeper:    newBudgetKeeper(budget)}, nil
 }
 
 func aptInstall(pkgs []string) error {
@@ -150,7 +253,9 @@ func (jobQueue *JobQueue) addJob(job Job) error {
 		return err
 	}
 
+	jobQueue.keeper.addJob(jobQueue.configDir, &job)
 	jobQueue.ticker.AddJob(job)
+	jobQueue.trigger.AddJob(job)
 	return nil
 }
 
@@ -173,7 +278,10 @@ func (jobQueue *JobQueue) removeJob(jobName string) error {
 	}
 
 	delete(jobQueue.jobProtos, jobName)
+	jobQueue.keeper.removeJob(jobName)
+	jobQueue.runner.triggerStates.Remove(jobName)
 	jobQueue.ticker.RemoveJob(jobName)
+	jobQueue.trigger.RemoveJob(jobName)
 	return nil
 }
 
@@ -183,12 +291,28 @@ func (jobQueue *JobQueue) handleJobInstance(jobName string) {
 		return
 	}
 
-	err := jobQueue.runner.Add(jobQueue.jobProtos[jobName])
+	job := jobQueue.jobProtos[jobName]
+	if status := jobQueue.keeper.admit(jobName); status != "" {
+		jobQueue.runner.record(&job, status)
+		return
+	}
+
+	err := jobQueue.runner.Add(job)
 	if err != nil {
 		logrus.Errorf(err.Error())
 	}
 }
 
+func (jobQueue *JobQueue) handleJobResult(result JobResult) {
+	if result.Started {
+		jobQueue.keeper.start(result.JobName)
+		return
+	}
+	if jobName := jobQueue.keeper.release(result); jobName != "" {
+		jobQueue.handleJobInstance(jobName)
+	}
+}
+
 func (jobQueue *JobQueue) run(ctx context.Context) {
 	for {
 		select {
@@ -202,12 +326,17 @@ func (jobQueue *JobQueue) run(ctx context.Context) {
 			jobQueue.Comm.Ack <- jobQueue.removeJob(jobName)
 		case jobName := <-jobQueue.ticker.ReadyJobs:
 			jobQueue.handleJobInstance(jobName)
+		case jobName := <-jobQueue.trigger.ReadyJobs:
+			jobQueue.handleJobInstance(jobName)
+		case result := <-jobQueue.runner.Completed:
+			jobQueue.handleJobResult(result)
 		}
 	}
 }
 
 func (jobQueue *JobQueue) Run(ctx context.Context) {
 	jobQueue.ticker.Run(ctx)
+	jobQueue.trigger.Run(ctx)
 	jobQueue.runner.Run(ctx)
 	go jobQueue.run(ctx)
 }
@@ -14,16 +14,34 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+type jobInstance struct {
+	cancel    context.CancelFunc
+	pending   *Job
+	coalesced uint32
+}
+
+// JobResult tells a run has finished, or only that its content tasks have
+// started if Started is set.
+type JobResult struct {
+	JobName   string
+	Continued bool
+	Started   bool
+}
+
 type JobRunner struct {
+	Completed      chan JobResult
 	configDir      string
 	logDir         string
 	storEngine     storage.StorEngine
+	history        storage.HistoryStore
+	triggerStates  *TriggerStates
 	jobCompleteSub chan log.LogMetaPubFormat
 	quit           chan bool
-	jobsInProcess  sync.Map
+	mutex          sync.Mutex
+	jobsInProcess  map[string]*jobInstance
 }
 
-func NewJobRunner(configDir, logDir, storEngine string, jobCompleteSub chan log.LogMetaPubFormat) (*JobRunner, error) {
+func NewJobRunner(configDir, logDir, storEngine string, history storage.HistoryStore, jobCompleteSub chan log.LogMetaPubFormat) (*JobRunner, error) {
 	err := log.NewLogPathManager(logDir).Prepare()
 	if err != nil {
 		return nil, err
@@ -35,62 +53,180 @@ func NewJobRunner(configDir, logDir, storEngine string, jobCompleteSub chan log.
 	}
 
 	return &JobRunner{
+		Completed:      make(chan JobResult, 16),
 		configDir:      configDir,
 		logDir:         logDir,
 		storEngine:     storEngineInst,
+		history:        history,
+		triggerStates:  NewTriggerStates(),
 		jobCompleteSub: jobCompleteSub,
 		quit:           make(chan bool),
-		jobsInProcess:  sync.Map{}}, nil
+		jobsInProcess:  make(map[string]*jobInstance)}, nil
 }
 
-func (runner *JobRunner) newJob(job Job) {
+func (runner *JobRunner) getJobContext(job *Job) (context.Context, context.CancelFunc) {
+	if job.MaxDuration == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), time.Duration(job.MaxDuration)*time.Second)
+}
+
+func (runner *JobRunner) getInterruptedStatus(ctx context.Context) string {
+	if ctx.Err() == context.DeadlineExceeded {
+		return log.JobTimedOut
+	}
+	return log.JobCancelled
+}
+
+func (runner *JobRunner) publish(timestamp int64, logMeta log.LogMetadata) {
+	if err := runner.storEngine.Save(timestamp, logMeta); err != nil {
+		logrus.Errorf("Failed to save log metadata, err [%s]", err)
+	}
+
+	if runner.jobCompleteSub != nil {
+		runner.jobCompleteSub <- log.LogMetaPubFormat{
+			Timestamp:   timestamp,
+			LogMetadata: logMeta,
+		}
+	}
+}
+
+func (runner *JobRunner) saveHistory(record *log.RunRecord) {
+	record.EndTime = time.Now().Unix()
+	if err := runner.history.Append(*record); err != nil {
+		logrus.Errorf("Failed to save run history of job [%s], err [%s]", record.JobName, err)
+	}
+}
+
+func (runner *JobRunner) record(job *Job, status string) {
+	timestamp := time.Now().Unix()
 	logMeta := log.LogMetadata{
 		JobName:   job.Name,
 		DataLabel: uuid.NewString(),
+		Status:    status,
+	}
+	runner.saveHistory(&log.RunRecord{
+		JobName:   logMeta.JobName,
+		DataLabel: logMeta.DataLabel,
+		StartTime: timestamp,
+		Status:    status,
+	})
+	go runner.publish(timestamp, logMeta)
+}
+
+// start must be called with the mutex held.
+func (runner *JobRunner) start(job Job, coalesced uint32) {
+	ctx, cancel := runner.getJobContext(&job)
+	runner.jobsInProcess[job.Name] = &jobInstance{
+		cancel: cancel,
+	}
+	go runner.newJob(ctx, job, coalesced)
+}
+
+func (runner *JobRunner) finish(job *Job) {
+	runner.mutex.Lock()
+	instance, isExist := runner.jobsInProcess[job.Name]
+	if !isExist {
+		runner.mutex.Unlock()
+		return
+	}
+	instance.cancel()
+	delete(runner.jobsInProcess, job.Name)
+
+	continued := instance.pending != nil
+	if continued {
+		runner.start(*instance.pending, instance.coalesced)
+	}
+	runner.mutex.Unlock()
+
+	runner.Completed <- JobResult{
+		JobName:   job.Name,
+		Continued: continued,
+	}
+}
+
+func (runner *JobRunner) newJob(ctx context.Context, job Job, coalesced uint32) {
+	logMeta := log.LogMetadata{
+		JobName:   job.Name,
+		DataLabel: uuid.NewString(),
+		Status:    log.JobCompleted,
+		Coalesced: coalesced,
 	}
 	timestamp := time.Now().Unix()
-	runner.jobsInProcess.Store(job.Name, timestamp)
-	defer runner.jobsInProcess.Delete(job.Name)
+	defer runner.finish(&job)
+
+	history := log.RunRecord{
+		JobName:   job.Name,
+		DataLabel: logMeta.DataLabel,
+		StartTime: timestamp,
+		Status:    log.JobFailed,
+	}
+	defer runner.saveHistory(&history)
 
 	routineName := job.Start
+	started := false
 
+routineLoop:
 	for routineName != "" {
 		routine, isExist := job.Routines[routineName]
 		if !isExist {
-			logrus.Errorf("Routine [%s] does not exist", routineName)
+			history.Error = fmt.Sprintf("Routine [%s] does not exist", routineName)
+			logrus.Errorf(history.Error)
 			return
 		}
+		history.Routines = append(history.Routines, routineName)
 
 		task, err := NewTask(runner.configDir, routine)
 		if err != nil {
+			history.Error = err.Error()
 			logrus.Errorf(err.Error())
 			return
 		}
 
-		if task.Cond.Type == common.None && task.Task.Type == common.None {
+		if task.Cond == nil && task.Task.Type == common.None {
 			break
 		}
 
-		if task.Cond.Type != common.None {
-			err = task.Condition(runner.logDir, logMeta.DataLabel)
-			logMeta.AddMetadata(log.Metadata{
-				TaskType:       int(task.Cond.Type),
-				LogDataPostfix: task.GetCondLogDataPathPostfix(),
-			})
+		if task.Cond != nil {
+			err = task.Condition(ctx, runner.logDir, logMeta.DataLabel, runner.triggerStates.Scope(job.Name, routineName))
+			if ctx.Err() != nil {
+				logMeta.Status = runner.getInterruptedStatus(ctx)
+				logrus.Warnf("Job [%s] is interrupted in routine [%s] condition, status [%s].",
+					job.Name, routineName, logMeta.Status)
+				break
+			}
+			for _, meta := range task.GetCondMetadatas() {
+				logMeta.AddMetadata(meta)
+			}
+			history.AddCondOutcome(routineName, err == nil)
 			if err != nil {
 				routineName = routine.CondFail
 				continue
 			}
+			if targets := task.GetCondTargets(); !targets.IsEmpty() {
+				ctx = WithTargets(ctx, targets)
+			}
 		}
 
 		if task.Task.Type != common.None {
-			errChan := make(chan error)
-			task.Process(runner.logDir, logMeta.DataLabel, errChan)
+			if !started {
+				started = true
+				runner.Completed <- JobResult{JobName: job.Name, Started: true}
+			}
+			errChan := make(chan error, 1)
+			task.Process(ctx, runner.logDir, logMeta.DataLabel, errChan)
 			select {
 			case <-runner.quit:
+				history.Status = log.JobCancelled
 				return
+			case <-ctx.Done():
+				logMeta.Status = runner.getInterruptedStatus(ctx)
+				logrus.Warnf("Job [%s] is interrupted in routine [%s], status [%s].",
+					job.Name, routineName, logMeta.Status)
+				break routineLoop
 			case err := <-errChan:
 				if err != nil {
+					history.Error = err.Error()
 					logrus.Errorf("Task [%s] failed, err [%s].", routineName, err)
 					return
 				}
@@ -103,25 +239,35 @@ func (runner *JobRunner) newJob(job Job) {
 		routineName = routine.CondSucc
 	}
 
-	if err := runner.storEngine.Save(timestamp, logMeta); err != nil {
-		logrus.Errorf("Failed to save log metadata, err [%s]", err)
-	}
-
-	if runner.jobCompleteSub != nil {
-		runner.jobCompleteSub <- log.LogMetaPubFormat{
-			Timestamp:   timestamp,
-			LogMetadata: logMeta,
-		}
-	}
+	history.Status = logMeta.Status
+	runner.publish(timestamp, logMeta)
 }
 
 func (runner *JobRunner) Add(job Job) error {
-	if _, isExist := runner.jobsInProcess.Load(job.Name); isExist {
-		return fmt.Errorf("Job [%s] is still processing", job.Name)
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+
+	instance, isExist := runner.jobsInProcess[job.Name]
+	if !isExist {
+		runner.start(job, 0)
+		return nil
+	}
+
+	switch job.Overlap {
+	case OverlapQueue, OverlapCancel:
+		if instance.pending != nil {
+			instance.coalesced++
+			runner.record(&job, log.JobCoalesced)
+		}
+		instance.pending = &job
+		if job.Overlap == OverlapCancel {
+			instance.cancel()
+		}
+		return nil
 	}
 
-	go runner.newJob(job)
-	return nil
+	runner.record(&job, log.JobSkipped)
+	return fmt.Errorf("Job [%s] is still processing", job.Name)
 }
 
 func (runner *JobRunner) run(ctx context.Context) {
@@ -2,8 +2,13 @@ package collector
 
 import (
 	"context"
+	"math/rand"
 	"time"
 
+	"hermes/common"
+	"hermes/log"
+	"hermes/storage"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -11,53 +16,136 @@ type JobTicker struct {
 	ReadyJobs chan string
 	quit      chan bool
 	cancel    map[string]chan bool
+	history   storage.HistoryStore
 }
 
-func NewJobTicker() (*JobTicker, error) {
+func NewJobTicker(history storage.HistoryStore) (*JobTicker, error) {
 	return &JobTicker{
 		ReadyJobs: make(chan string, 16),
+		history:   history,
 		quit:      make(chan bool),
 		cancel:    make(map[string]chan bool)}, nil
 }
 
-func (jobTicker *JobTicker) AddJob(job Job) {
-	jobTicker.cancel[job.Name] = make(chan bool)
+// getNextTime returns when the job should fire after the previous firing
+// time, or the zero time if it should not fire anymore. A firing missed
+// while the collector was down is caught up once at now.
+func (jobTicker *JobTicker) getNextTime(job *Job, now, prev time.Time) time.Time {
+	schedule := &job.Schedule
+	var next time.Time
+
+	switch job.Class {
+	case Disposable:
+		if !prev.IsZero() {
+			return time.Time{}
+		}
+		next = now
+		if schedule.startTime.After(next) {
+			next = schedule.startTime
+		}
+	case Periodic:
+		interval := time.Duration(job.Interval) * time.Second
+		if prev.IsZero() {
+			next = now.Add(interval)
+		} else {
+			next = prev.Add(interval)
+		}
+		if schedule.startTime.After(next) {
+			next = schedule.startTime
+		}
+	case Cron:
+		from := now
+		if !prev.IsZero() {
+			from = prev
+		}
+		if schedule.startTime.After(from) {
+			from = schedule.startTime.Add(-time.Nanosecond)
+		}
+		next = schedule.cronExpr.Next(from)
+	}
+
+	if job.Class != Disposable && !next.IsZero() && next.Before(now) {
+		next = now
+	}
+
+	if next.IsZero() || (!schedule.endTime.IsZero() && next.After(schedule.endTime)) {
+		return time.Time{}
+	}
+	return next
+}
+
+// getJitter draws from the random of the schedule goroutine of the job, as
+// rand.Rand isn't safe for concurrent use.
+func (jobTicker *JobTicker) getJitter(job *Job, random *rand.Rand) time.Duration {
+	if job.Schedule.Jitter == 0 {
+		return 0
+	}
+	return time.Duration(random.Int63n(int64(job.Schedule.Jitter) * int64(time.Second)))
+}
 
-	if job.Class == Disposable {
-		go func() {
-			timer := time.NewTimer(time.Microsecond)
-			defer timer.Stop()
-			select {
-			case <-jobTicker.quit:
-			case <-jobTicker.cancel[job.Name]:
-				return
-			case <-timer.C:
-				jobTicker.ReadyJobs <- job.Name
-			}
-		}()
-	} else if job.Class == Periodic {
-		go func() {
-			ticker := time.NewTicker(time.Duration(job.Interval) * time.Second)
-			defer ticker.Stop()
-
-			for {
-				select {
-				case <-jobTicker.quit:
-				case <-jobTicker.cancel[job.Name]:
-					return
-				case <-ticker.C:
-					jobTicker.ReadyJobs <- job.Name
-				}
-			}
-		}()
-	} else {
+// getResumeTime returns the previous firing time recorded in the run
+// history, so that a restarted collector resumes the schedule of the job.
+func (jobTicker *JobTicker) getResumeTime(job *Job) time.Time {
+	last, err := jobTicker.history.Last(job.Name)
+	if err != nil {
+		logrus.Warnf("Failed to load run history of job [%s], err [%s]", job.Name, err)
+		return time.Time{}
+	}
+	if last == nil {
+		return time.Time{}
+	}
+
+	// disposable jobs are only done once they have completed
+	if job.Class == Disposable && last.Status != log.JobCompleted {
+		return time.Time{}
+	}
+	return time.Unix(last.StartTime, 0)
+}
+
+func (jobTicker *JobTicker) schedule(job Job, cancel chan bool) {
+	prev := jobTicker.getResumeTime(&job)
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		next := jobTicker.getNextTime(&job, time.Now(), prev)
+		if next.IsZero() {
+			break
+		}
+
+		timer := time.NewTimer(time.Until(next.Add(jobTicker.getJitter(&job, random))))
+		select {
+		case <-jobTicker.quit:
+			timer.Stop()
+			return
+		case <-cancel:
+			timer.Stop()
+			return
+		case <-timer.C:
+			jobTicker.ReadyJobs <- job.Name
+		}
+		prev = next
+	}
+}
+
+func (jobTicker *JobTicker) AddJob(job Job) {
+	// event jobs are only fired by their triggers
+	if job.Class == Event {
+		return
+	}
+	if !common.Contains([]string{Disposable, Periodic, Cron}, job.Class) {
 		logrus.Errorf("Unhandled class [%s]", job.Class)
+		return
 	}
+
+	jobTicker.cancel[job.Name] = make(chan bool)
+	go jobTicker.schedule(job, jobTicker.cancel[job.Name])
 }
 
+// RemoveJob closes the cancel channel of the job, which doesn't block if the
+// schedule of the job has already ended.
 func (jobTicker *JobTicker) RemoveJob(jobName string) {
-	if _, isExist := jobTicker.cancel[jobName]; isExist {
-		jobTicker.cancel[jobName] <- true
+	if cancel, isExist := jobTicker.cancel[jobName]; isExist {
+		close(cancel)
+		delete(jobTicker.cancel, jobName)
 	}
 }
 
@@ -0,0 +1,281 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"hermes/backend/utils"
+	"hermes/common"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	PSITrigger    = "psi"
+	FileTrigger   = "file"
+	KmsgTrigger   = "kmsg"
+	SignalTrigger = "signal"
+)
+
+const (
+	triggerPollTimeout = time.Second
+	triggerDebounce    = time.Second
+	// without CAP_SYS_RESOURCE the kernel only accepts multiples of 2s
+	psiTriggerWindow = 2000000
+)
+
+var fileTriggerOps = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"remove": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+	"chmod":  fsnotify.Chmod,
+}
+
+// Trigger is an event that fires its job besides the time-based schedule.
+//
+//	psi:    resource (cpu, memory or io), stall (some or full), threshold and
+//	        window in microseconds, path to watch a cgroup instead of the system
+//	file:   path, events (create, write, remove, rename or chmod), the path
+//	        may be a file yet to be created under an existing directory
+//	kmsg:   pattern matched against new kernel log records
+//	signal: signal name, e.g. SIGUSR1
+type Trigger struct {
+	Type      string   `yaml:"type"`
+	Resource  string   `yaml:"resource,omitempty"`
+	Stall     string   `yaml:"stall,omitempty"`
+	Threshold uint32   `yaml:"threshold,omitempty"`
+	Window    uint32   `yaml:"window,omitempty"`
+	Path      string   `yaml:"path,omitempty"`
+	Events    []string `yaml:"events,omitempty"`
+	Pattern   string   `yaml:"pattern,omitempty"`
+	Signal    string   `yaml:"signal,omitempty"`
+}
+
+func (trigger *Trigger) check() error {
+	switch trigger.Type {
+	case PSITrigger:
+		if !common.Contains([]utils.PSIType{utils.CpuPSI, utils.MemoryPSI, utils.IOPSI}, utils.PSIType(trigger.Resource)) {
+			return fmt.Errorf("Unrecognized psi resource [%s]", trigger.Resource)
+		}
+		if trigger.Stall == "" {
+			trigger.Stall = utils.PSISome
+		} else if !common.Contains([]string{utils.PSISome, utils.PSIFull}, trigger.Stall) {
+			return fmt.Errorf("Unrecognized psi stall [%s]", trigger.Stall)
+		}
+		if trigger.Window == 0 {
+			trigger.Window = psiTriggerWindow
+		}
+		if trigger.Threshold == 0 || trigger.Threshold > trigger.Window {
+			return fmt.Errorf("The psi threshold should be within (0, %d]", trigger.Window)
+		}
+	case FileTrigger:
+		if trigger.Path == "" {
+			return fmt.Errorf("The path of file trigger cannot be empty")
+		}
+		for _, event := range trigger.Events {
+			if _, isExist := fileTriggerOps[event]; !isExist {
+				return fmt.Errorf("Unrecognized file event [%s]", event)
+			}
+		}
+	case KmsgTrigger:
+		if _, err := regexp.Compile(trigger.Pattern); err != nil || trigger.Pattern == "" {
+			return fmt.Errorf("Unexpected kmsg pattern [%s]", trigger.Pattern)
+		}
+	case SignalTrigger:
+		if unix.SignalNum(strings.ToUpper(trigger.Signal)) == 0 {
+			return fmt.Errorf("Unrecognized signal [%s]", trigger.Signal)
+		}
+	default:
+		return fmt.Errorf("Unrecognized trigger type [%s]", trigger.Type)
+	}
+	return nil
+}
+
+type JobTrigger struct {
+	ReadyJobs chan string
+	cancel    map[string]context.CancelFunc
+	ctx       context.Context
+}
+
+func NewJobTrigger() (*JobTrigger, error) {
+	return &JobTrigger{
+		ReadyJobs: make(chan string, 16),
+		cancel:    make(map[string]context.CancelFunc),
+		ctx:       context.Background()}, nil
+}
+
+// fire hands the job to the queue, events of a burst within the debounce
+// time are merged.
+func (jobTrigger *JobTrigger) fire(ctx context.Context, jobName string, trigger *Trigger, last *time.Time, mutex *sync.Mutex) {
+	mutex.Lock()
+	if time.Since(*last) < triggerDebounce {
+		mutex.Unlock()
+		return
+	}
+	*last = time.Now()
+	mutex.Unlock()
+
+	logrus.Infof("Job [%s] is triggered by [%s]", jobName, trigger.Type)
+	select {
+	case <-ctx.Done():
+	case jobTrigger.ReadyJobs <- jobName:
+	}
+}
+
+func (jobTrigger *JobTrigger) watchPSI(ctx context.Context, trigger *Trigger, fire func()) error {
+	path := utils.GetSystemPressurePath(utils.PSIType(trigger.Resource))
+	if trigger.Path != "" {
+		path = filepath.Join(utils.GetCgroupPath(trigger.Path), trigger.Resource+utils.PressurePostfix)
+	}
+	psiTrigger, err := utils.NewPSITrigger(path, trigger.Stall, trigger.Threshold, trigger.Window)
+	if err != nil {
+		return err
+	}
+	defer psiTrigger.Close()
+
+	for ctx.Err() == nil {
+		fired, err := psiTrigger.Wait(triggerPollTimeout)
+		if err != nil {
+			return err
+		}
+		if fired {
+			fire()
+		}
+	}
+	return nil
+}
+
+func (jobTrigger *JobTrigger) watchFile(ctx context.Context, trigger *Trigger, fire func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// a file is watched through its directory, so that it may be created
+	// later or replaced by a rename, the events of the others are dropped
+	path, name := filepath.Clean(trigger.Path), ""
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		path, name = filepath.Dir(path), path
+	}
+	if err := watcher.Add(path); err != nil {
+		return err
+	}
+
+	var ops fsnotify.Op
+	for _, event := range trigger.Events {
+		ops |= fileTriggerOps[event]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if name != "" && filepath.Clean(event.Name) != name {
+				continue
+			}
+			if ops == 0 || event.Op&ops != 0 {
+				fire()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logrus.Errorf("File trigger on [%s] failed, err [%s]", trigger.Path, err)
+		}
+	}
+}
+
+func (jobTrigger *JobTrigger) watchKmsg(ctx context.Context, trigger *Trigger, fire func()) error {
+	pattern := regexp.MustCompile(trigger.Pattern)
+	watcher, err := utils.NewKmsgWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for ctx.Err() == nil {
+		records, err := watcher.Wait(triggerPollTimeout)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if pattern.MatchString(record.Message) {
+				fire()
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (jobTrigger *JobTrigger) watchSignal(ctx context.Context, trigger *Trigger, fire func()) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SignalNum(strings.ToUpper(trigger.Signal)))
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-signals:
+			fire()
+		}
+	}
+}
+
+func (jobTrigger *JobTrigger) watch(ctx context.Context, jobName string, trigger Trigger, fire func()) {
+	watchFuncs := map[string]func(context.Context, *Trigger, func()) error{
+		PSITrigger:    jobTrigger.watchPSI,
+		FileTrigger:   jobTrigger.watchFile,
+		KmsgTrigger:   jobTrigger.watchKmsg,
+		SignalTrigger: jobTrigger.watchSignal,
+	}
+	if err := watchFuncs[trigger.Type](ctx, &trigger, fire); err != nil {
+		logrus.Errorf("Trigger [%s] of job [%s] stopped, err [%s]", trigger.Type, jobName, err)
+	}
+}
+
+func (jobTrigger *JobTrigger) AddJob(job Job) {
+	if len(job.Triggers) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(jobTrigger.ctx)
+	jobTrigger.cancel[job.Name] = cancel
+
+	var last time.Time
+	var mutex sync.Mutex
+	for i := range job.Triggers {
+		trigger := &job.Triggers[i]
+		go jobTrigger.watch(ctx, job.Name, *trigger, func() {
+			jobTrigger.fire(ctx, job.Name, trigger, &last, &mutex)
+		})
+	}
+}
+
+func (jobTrigger *JobTrigger) RemoveJob(jobName string) {
+	if cancel, isExist := jobTrigger.cancel[jobName]; isExist {
+		cancel()
+		delete(jobTrigger.cancel, jobName)
+	}
+}
+
+// Run must be called before any job is added, the watchers stop along with
+// the context.
+func (jobTrigger *JobTrigger) Run(ctx context.Context) {
+	jobTrigger.ctx = ctx
+}
@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWatchFileCreate checks that a file yet to be created is watched, and
+// that the events of the other files of its directory are dropped.
+func TestWatchFileCreate(t *testing.T) {
+	dir := t.TempDir()
+	trigger := Trigger{
+		Type:   FileTrigger,
+		Path:   filepath.Join(dir, "file"),
+		Events: []string{"create"},
+	}
+	if err := trigger.check(); err != nil {
+		t.Fatal(err)
+	}
+	jobTrigger, err := NewJobTrigger()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fired := make(chan struct{}, 16)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- jobTrigger.watchFile(ctx, &trigger, func() { fired <- struct{}{} })
+	}()
+	// the watch is added by the goroutine
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.WriteFile(filepath.Join(dir, "other"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fired:
+		t.Fatal("The trigger is fired by another file")
+	case err := <-errChan:
+		t.Fatalf("The watch ended, err [%v]", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(trigger.Path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fired:
+	case err := <-errChan:
+		t.Fatalf("The watch ended, err [%v]", err)
+	case <-time.After(time.Second):
+		t.Fatal("The trigger isn't fired by the creation of the file")
+	}
+}
@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+const (
+	TargetPidsEnv   = "TARGET_PIDS"
+	TargetCgroupEnv = "TARGET_CGROUP"
+)
+
+// Targets are the processes and cgroup matched by a condition task, they
+// are handed to the content tasks of the following routines.
+type Targets struct {
+	Pids   []int32 `json:"pids,omitempty"`
+	Cgroup string  `json:"cgroup,omitempty"`
+}
+
+// TargetContext is implemented by the condition contexts that select
+// targets.
+type TargetContext interface {
+	GetTargets() *Targets
+}
+
+type targetsKey struct{}
+
+func (targets *Targets) merge(other *Targets) {
+	for _, pid := range other.Pids {
+		found := false
+		for _, _pid := range targets.Pids {
+			if pid == _pid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			targets.Pids = append(targets.Pids, pid)
+		}
+	}
+	if targets.Cgroup == "" {
+		targets.Cgroup = other.Cgroup
+	}
+}
+
+func (targets *Targets) IsEmpty() bool {
+	return len(targets.Pids) == 0 && targets.Cgroup == ""
+}
+
+// Env returns the targets as environment variables for binary tasks.
+func (targets *Targets) Env() map[string]string {
+	pids := make([]string, 0, len(targets.Pids))
+	for _, pid := range targets.Pids {
+		pids = append(pids, strconv.Itoa(int(pid)))
+	}
+	return map[string]string{
+		TargetPidsEnv:   strings.Join(pids, ","),
+		TargetCgroupEnv: targets.Cgroup,
+	}
+}
+
+func WithTargets(ctx context.Context, targets *Targets) context.Context {
+	return context.WithValue(ctx, targetsKey{}, targets)
+}
+
+// GetTargets returns the targets selected by the previous conditions of the
+// job, or nil if there are none.
+func GetTargets(ctx context.Context) *Targets {
+	targets, _ := ctx.Value(targetsKey{}).(*Targets)
+	return targets
+}
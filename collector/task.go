@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -26,6 +27,15 @@ var InstGetMapping = map[common.TaskType]func(common.TaskType) (TaskInstance, er
 	common.PSI:        NewTaskPSIInstance,
 	common.CpuInfo:    NewCpuInfoInstance,
 	common.MemoryInfo: NewMemoryInfoInstance,
+	common.LoadAvg:    NewLoadAvgInstance,
+	common.Process:    NewProcessInstance,
+	common.Kmsg:       NewKmsgInstance,
+}
+
+var HeavyTaskTypes = []common.TaskType{
+	common.Trace,
+	common.Profile,
+	common.Ebpf,
 }
 
 type TaskContext struct {
@@ -34,39 +44,45 @@ type TaskContext struct {
 }
 
 type TaskInstance interface {
-	GetLogDataPathPostfix(context interface{}) string
-	Process(context interface{}, logPathManager log.LogPathManager, result chan error)
+	GetLogDataPathPostfix(instContext interface{}) string
+	Process(ctx context.Context, instContext interface{}, logPathManager log.LogPathManager, result chan error)
 }
 
 type Task struct {
-	Cond TaskContext
+	Cond *CondExpr
 	Task TaskContext
 }
 
 func unmarshalTask(taskType string, param, paramOverride *[]byte, taskContext *TaskContext) error {
-	var context common.Context
+	var instContext common.Context
 	switch taskType {
 	case common.BinaryTask:
-		context = &BinaryContext{}
+		instContext = &BinaryContext{}
 	case common.TraceTask:
-		context = &TraceContext{}
+		instContext = &TraceContext{}
 	case common.ProfileTask:
-		context = &ProfileContext{}
+		instContext = &ProfileContext{}
 	case common.EbpfTask:
-		context = &EbpfContext{}
+		instContext = &EbpfContext{}
 	case common.PSITask:
-		context = &PSIContext{}
+		instContext = &PSIContext{}
 	case common.CpuInfoTask:
-		context = &CpuInfoContext{}
+		instContext = &CpuInfoContext{}
 	case common.MemoryInfoTask:
-		context = &MemoryInfoContext{}
+		instContext = &MemoryInfoContext{}
+	case common.LoadAvgTask:
+		instContext = &LoadAvgContext{}
+	case common.ProcessTask:
+		instContext = &ProcessContext{}
+	case common.KmsgTask:
+		instContext = &KmsgContext{}
 	}
 
-	if err := context.Fill(param, paramOverride); err != nil {
+	if err := instContext.Fill(param, paramOverride); err != nil {
 		return err
 	}
 
-	taskContext.Context = context
+	taskContext.Context = instContext
 	taskContext.Type = common.TaskNameToType(taskType)
 	return nil
 }
@@ -102,10 +118,11 @@ func loadTask(configDir, taskName string, paramOverride interface{}, taskContext
 func NewTask(configDir string, routine Routine) (*Task, error) {
 	var task Task
 	if len(routine.Cond) == 1 {
-		taskName := reflect.ValueOf(routine.Cond).MapKeys()[0].Interface().(string)
-		if err := loadTask(configDir, taskName, routine.Cond[taskName], &task.Cond); err != nil {
+		cond, err := newCondExpr(configDir, routine.Cond)
+		if err != nil {
 			return nil, err
 		}
+		task.Cond = cond
 	}
 
 	if len(routine.Task) == 1 {
@@ -126,33 +143,40 @@ func (task *Task) getInstance(taskType common.TaskType) (TaskInstance, error) {
 	return getInst(taskType)
 }
 
-func (task *Task) execute(context *TaskContext, logDir, logDataLabel string, errChan chan error) {
-	instance, err := task.getInstance(context.Type)
+func (task *Task) execute(ctx context.Context, taskContext *TaskContext, logDir, logDataLabel string, errChan chan error) {
+	instance, err := task.getInstance(taskContext.Type)
 	if err != nil {
 		errChan <- err
 		return
 	}
 
 	logPathManager := log.NewLogPathManager(logDir).SetDataLabel(logDataLabel)
-	instance.Process(context.Context, *logPathManager, errChan)
+	instance.Process(ctx, taskContext.Context, *logPathManager, errChan)
 }
 
-func (task *Task) GetCondLogDataPathPostfix() string {
-	instance, err := task.getInstance(task.Cond.Type)
-	if err != nil {
-		return "*"
+// GetCondMetadatas returns the log metadata of every condition task along
+// with its own result.
+func (task *Task) GetCondMetadatas() []log.Metadata {
+	if task.Cond == nil {
+		return nil
 	}
-	return ".cond" + instance.GetLogDataPathPostfix(task.Cond.Context)
+	return task.Cond.metadatas(task)
 }
 
-func (task *Task) Condition(logDir, logDataLabel string) error {
-	if task.Cond.Type == common.None {
+// GetCondTargets returns the targets selected by the triggered condition
+// tasks.
+func (task *Task) GetCondTargets() *Targets {
+	if task.Cond == nil {
 		return nil
 	}
+	return task.Cond.targets()
+}
 
-	errChan := make(chan error)
-	go task.execute(&task.Cond, logDir, logDataLabel+".cond", errChan)
-	return <-errChan
+func (task *Task) Condition(ctx context.Context, logDir, logDataLabel string, scope *TriggerScope) error {
+	if task.Cond == nil {
+		return nil
+	}
+	return task.Cond.evaluate(ctx, task, logDir, logDataLabel, scope)
 }
 
 func (task *Task) GetTaskLogDataPathPostfix() string {
@@ -163,7 +187,7 @@ func (task *Task) GetTaskLogDataPathPostfix() string {
 	return ".task" + instance.GetLogDataPathPostfix(task.Task.Context)
 }
 
-func (task *Task) Process(logDir, logDataLabel string, errChan chan error) {
+func (task *Task) Process(ctx context.Context, logDir, logDataLabel string, errChan chan error) {
 	var err error
 	if task.Task.Type == common.None {
 		go func() {
@@ -171,5 +195,5 @@ func (task *Task) Process(logDir, logDataLabel string, errChan chan error) {
 		}()
 		return
 	}
-	go task.execute(&task.Task, logDir, logDataLabel+".task", errChan)
+	go task.execute(ctx, &task.Task, logDir, logDataLabel+".task", errChan)
 }
@@ -1,21 +1,66 @@
 package collector
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"hermes/common"
 	"hermes/log"
 )
 
+const (
+	CompareGE = ">="
+	CompareGT = ">"
+	CompareLE = "<="
+	CompareLT = "<"
+	CompareEQ = "=="
+	CompareNE = "!="
+)
+
+// BinaryContext runs a command. Used as a condition it holds when the
+// command exits with 0 and, if a threshold is set, the value printed on
+// stdout compares to it. Pattern extracts the value with its first
+// submatch, otherwise the whole stdout is the value.
 type BinaryContext struct {
-	Cmds []string
+	TriggerOptions `yaml:",inline"`
+	Cmds           []string
+	Timeout        uint32   `yaml:"timeout,omitempty"`
+	Pattern        string   `yaml:"pattern,omitempty"`
+	Threshold      *float64 `yaml:"threshold,omitempty"`
+	Compare        string   `yaml:"compare,omitempty"`
+	Value          *float64 `yaml:"-"`
+	Triggered      bool     `yaml:"-"`
+
+	pattern *regexp.Regexp
 }
 
 func (context *BinaryContext) check() error {
+	var err error
 	if len(context.Cmds) == 0 {
 		return fmt.Errorf("The cmds cannot be empty")
 	}
+	if context.Compare == "" {
+		context.Compare = CompareGE
+	} else if !common.Contains([]string{CompareGE, CompareGT, CompareLE, CompareLT, CompareEQ, CompareNE}, context.Compare) {
+		return fmt.Errorf("Unrecognized compare [%s]", context.Compare)
+	}
+	if context.Pattern != "" {
+		if context.pattern, err = regexp.Compile(context.Pattern); err != nil {
+			return err
+		}
+		if context.pattern.NumSubexp() < 1 {
+			return fmt.Errorf("The pattern [%s] needs a submatch for the value", context.Pattern)
+		}
+	}
 	return nil
 }
 
@@ -26,6 +71,43 @@ func (context *BinaryContext) Fill(param, paramOverride *[]byte) error {
 	return context.check()
 }
 
+func (context *BinaryContext) IsTriggered() bool {
+	return context.Triggered
+}
+
+func (context *BinaryContext) IsCleared() (bool, bool) {
+	return false, false
+}
+
+func (context *BinaryContext) parseValue(output []byte) (float64, error) {
+	text := string(output)
+	if context.pattern != nil {
+		matches := context.pattern.FindStringSubmatch(text)
+		if matches == nil {
+			return 0, fmt.Errorf("The output does not match pattern [%s]", context.Pattern)
+		}
+		text = matches[1]
+	}
+	return strconv.ParseFloat(strings.TrimSpace(text), 64)
+}
+
+func (context *BinaryContext) compare(val float64) bool {
+	threshold := *context.Threshold
+	switch context.Compare {
+	case CompareGT:
+		return val > threshold
+	case CompareLE:
+		return val <= threshold
+	case CompareLT:
+		return val < threshold
+	case CompareEQ:
+		return val == threshold
+	case CompareNE:
+		return val != threshold
+	}
+	return val >= threshold
+}
+
 type TaskBinaryInstance struct{}
 
 func NewTaskBinaryInstance(_ common.TaskType) (TaskInstance, error) {
@@ -36,24 +118,68 @@ func (instance *TaskBinaryInstance) GetLogDataPathPostfix(instContext interface{
 	return ".binary"
 }
 
-func (instance *TaskBinaryInstance) Process(instContext interface{}, logPathManager log.LogPathManager, result chan error) {
+func (instance *TaskBinaryInstance) Process(ctx context.Context, instContext interface{}, logPathManager log.LogPathManager, result chan error) {
 	binaryContext := instContext.(*BinaryContext)
 	var err error
 	defer func() {
 		result <- err
 	}()
 
+	// the job may be interrupted before the timeout of the command
+	jobCtx := ctx
+	if binaryContext.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(binaryContext.Timeout)*time.Second)
+		defer cancel()
+	}
+
 	logDataPath := logPathManager.DataPath(".binary")
 	env := map[string]string{
 		"OUTPUT_FILE": logDataPath,
 	}
-	cmd := PrepareCmd(binaryContext.Cmds, env)
+	if targets := GetTargets(ctx); targets != nil {
+		for key, val := range targets.Env() {
+			env[key] = val
+		}
+	}
+	cmd := PrepareCmd(ctx, binaryContext.Cmds, env)
 	outfile, err := os.Create(logDataPath)
 	if err != nil {
 		return
 	}
 	defer outfile.Close()
 
+	var output bytes.Buffer
 	cmd.Stdout = outfile
-	err = cmd.Run()
+	if binaryContext.Threshold != nil {
+		cmd.Stdout = io.MultiWriter(outfile, &output)
+	}
+	if err = cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if jobCtx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("Command [%s] is killed as the job deadline expired", binaryContext.Cmds[0])
+		} else if jobCtx.Err() != nil {
+			err = fmt.Errorf("Command [%s] is killed as the job is cancelled", binaryContext.Cmds[0])
+		} else if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("Command [%s] timed out after [%d]s", binaryContext.Cmds[0], binaryContext.Timeout)
+		} else if errors.As(err, &exitErr) {
+			// the exit code of the command is its verdict as a condition
+			err = notTriggered("Command [%s] exited with [%d]", binaryContext.Cmds[0], exitErr.ExitCode())
+		}
+		return
+	}
+
+	if binaryContext.Threshold != nil {
+		val, parseErr := binaryContext.parseValue(output.Bytes())
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+		binaryContext.Value = &val
+		if !binaryContext.compare(val) {
+			err = notTriggered("Binary value [%g] does not satisfy [%s %g]", val, binaryContext.Compare, *binaryContext.Threshold)
+			return
+		}
+	}
+	binaryContext.Triggered = true
 }
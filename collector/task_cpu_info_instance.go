@@ -1,8 +1,8 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"os"
 
 	"hermes/backend/utils"
@@ -13,15 +13,28 @@ import (
 )
 
 type CpuInfoContext struct {
-	Threshold uint64
-	Usage     uint64
-	Triggered bool
+	TriggerOptions `yaml:",inline"`
+	Threshold      uint64
+	ClearThreshold *uint64 `yaml:"clear_threshold,omitempty" json:",omitempty"`
+	Usage          uint64
+	Triggered      bool
 }
 
 func (context *CpuInfoContext) Fill(param, paramOverride *[]byte) error {
 	return common.FillContext(param, paramOverride, context)
 }
 
+func (context *CpuInfoContext) IsTriggered() bool {
+	return context.Triggered
+}
+
+func (context *CpuInfoContext) IsCleared() (bool, bool) {
+	if context.ClearThreshold == nil {
+		return false, false
+	}
+	return context.Usage < *context.ClearThreshold, true
+}
+
 type TaskCpuInfoInstance struct{}
 
 func NewCpuInfoInstance(_ common.TaskType) (TaskInstance, error) {
@@ -53,7 +66,7 @@ func (instance *TaskCpuInfoInstance) GetLogDataPathPostfix(instContext interface
 	return ".cpuinfo"
 }
 
-func (instance *TaskCpuInfoInstance) Process(instContext interface{}, logPathManager log.LogPathManager, result chan error) {
+func (instance *TaskCpuInfoInstance) Process(_ context.Context, instContext interface{}, logPathManager log.LogPathManager, result chan error) {
 	cpuInfoContext := instContext.(*CpuInfoContext)
 	var err error
 	defer func() {
@@ -70,7 +83,7 @@ func (instance *TaskCpuInfoInstance) Process(instContext interface{}, logPathMan
 	if cpuInfoContext.Triggered {
 		err = nil
 	} else {
-		err = fmt.Errorf("CpuInfo value does not exceed threshold")
+		err = notTriggered("CpuInfo value does not exceed threshold")
 	}
 
 	logDataPath := logPathManager.DataPath(".cpuinfo")
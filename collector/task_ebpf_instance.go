@@ -54,7 +54,7 @@ func (instance *TaskEbpfInstance) GetLogDataPathPostfix(instContext interface{})
 	return loader.GetLogDataPathPostfix()
 }
 
-func (instance *TaskEbpfInstance) Process(instContext interface{}, logPathManager log.LogPathManager, result chan error) {
+func (instance *TaskEbpfInstance) Process(ctx context.Context, instContext interface{}, logPathManager log.LogPathManager, result chan error) {
 	ebpfContext := instContext.(*EbpfContext)
 	var loader ebpf.Loader
 	var err error
@@ -78,10 +78,14 @@ func (instance *TaskEbpfInstance) Process(instContext interface{}, logPathManage
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ebpfContext.Timeout)*time.Second)
+	loadCtx, cancel := context.WithTimeout(ctx, time.Duration(ebpfContext.Timeout)*time.Second)
 	defer cancel()
 
-	if err = loader.Load(ctx); err != nil {
+	if err = loader.Load(loadCtx); err != nil {
+		return
+	}
+	if err = ctx.Err(); err != nil {
+		loader.Close()
 		return
 	}
 	if err = loader.StoreData(logPathManager); err != nil {
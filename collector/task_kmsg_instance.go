@@ -0,0 +1,165 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"hermes/backend/utils"
+	"hermes/common"
+	"hermes/log"
+
+	"github.com/sirupsen/logrus"
+)
+
+type KmsgMatch struct {
+	Pattern string           `json:"pattern"`
+	Record  utils.KmsgRecord `json:"record"`
+}
+
+// KmsgContext matches the kernel log of the last Window seconds against
+// the named regular expressions. As a condition, only the records after
+// those read by the previous run of the job are matched.
+type KmsgContext struct {
+	TriggerOptions `yaml:",inline"`
+	Patterns       map[string]string `json:"patterns"`
+	Window         uint32            `yaml:"window" json:"window"`
+	Matches        []KmsgMatch       `json:"matches"`
+	Triggered      bool              `json:"triggered"`
+	TriggeredBy    string            `json:"triggered_by"`
+
+	patterns  map[string]*regexp.Regexp
+	cursor    uint64
+	hasCursor bool
+}
+
+func (context *KmsgContext) check() error {
+	if len(context.Patterns) == 0 {
+		return fmt.Errorf("The patterns cannot be empty")
+	}
+	if context.Window == 0 {
+		return fmt.Errorf("The window cannot be zero")
+	}
+	context.patterns = make(map[string]*regexp.Regexp, len(context.Patterns))
+	for name, pattern := range context.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("Failed to compile pattern [%s], err [%s]", name, err)
+		}
+		context.patterns[name] = re
+	}
+	return nil
+}
+
+func (context *KmsgContext) Fill(param, paramOverride *[]byte) error {
+	if err := common.FillContext(param, paramOverride, context); err != nil {
+		return err
+	}
+	return context.check()
+}
+
+func (context *KmsgContext) IsTriggered() bool {
+	return context.Triggered
+}
+
+func (context *KmsgContext) IsCleared() (bool, bool) {
+	return false, false
+}
+
+// SetCursor sets the seq of the last record read.
+func (context *KmsgContext) SetCursor(cursor uint64) {
+	context.cursor = cursor
+	context.hasCursor = true
+}
+
+func (context *KmsgContext) GetCursor() (uint64, bool) {
+	return context.cursor, context.hasCursor
+}
+
+type TaskKmsgInstance struct{}
+
+func NewKmsgInstance(_ common.TaskType) (TaskInstance, error) {
+	return &TaskKmsgInstance{}, nil
+}
+
+func (instance *TaskKmsgInstance) match(kmsgContext *KmsgContext, records []utils.KmsgRecord) {
+	names := make([]string, 0, len(kmsgContext.patterns))
+	for name := range kmsgContext.patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, record := range records {
+		for _, name := range names {
+			if kmsgContext.patterns[name].MatchString(record.Message) {
+				kmsgContext.Matches = append(kmsgContext.Matches, KmsgMatch{
+					Pattern: name,
+					Record:  record,
+				})
+				break
+			}
+		}
+	}
+}
+
+func (instance *TaskKmsgInstance) writeToFile(context *KmsgContext, path string) error {
+	bytes, err := json.Marshal(*context)
+	if err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	if _, err = fp.WriteString(string(bytes)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (instance *TaskKmsgInstance) GetLogDataPathPostfix(instContext interface{}) string {
+	return ".kmsg"
+}
+
+func (instance *TaskKmsgInstance) Process(_ context.Context, instContext interface{}, logPathManager log.LogPathManager, result chan error) {
+	kmsgContext := instContext.(*KmsgContext)
+	var err error
+	defer func() {
+		result <- err
+	}()
+
+	records, err := utils.ReadKmsg(time.Duration(kmsgContext.Window) * time.Second)
+	if err != nil {
+		logrus.Errorf("Failed to read kernel log, err [%s]", err)
+		return
+	}
+
+	newRecords := records[:0]
+	for _, record := range records {
+		if kmsgContext.hasCursor && record.Seq <= kmsgContext.cursor {
+			continue
+		}
+		newRecords = append(newRecords, record)
+		kmsgContext.SetCursor(record.Seq)
+	}
+
+	instance.match(kmsgContext, newRecords)
+	kmsgContext.Triggered = len(kmsgContext.Matches) != 0
+	if kmsgContext.Triggered {
+		kmsgContext.TriggeredBy = kmsgContext.Matches[0].Pattern
+		err = nil
+	} else {
+		err = notTriggered("Kernel log does not match patterns")
+	}
+
+	logDataPath := logPathManager.DataPath(".kmsg")
+	if err := instance.writeToFile(kmsgContext, logDataPath); err != nil {
+		logrus.Errorf("Failed to write to file [%s], err [%s]", logDataPath, err)
+	}
+}
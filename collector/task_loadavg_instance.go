@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"hermes/backend/utils"
+	"hermes/common"
+	"hermes/log"
+
+	"github.com/sirupsen/logrus"
+)
+
+var LoadAvgEntries = []string{"load1", "load5", "load15", utils.ProcsRunning, utils.ProcsBlocked}
+
+// LoadAvgContext thresholds are normalised per CPU, e.g. a load1 threshold
+// of 1.5 on an 8-CPU box fires when the load average reaches 12. An entry
+// without threshold is not checked.
+type LoadAvgContext struct {
+	TriggerOptions  `yaml:",inline"`
+	Thresholds      map[string]float64 `json:"thresholds"`
+	ClearThresholds map[string]float64 `yaml:"clear_thresholds,omitempty" json:"clear_thresholds,omitempty"`
+	CpuNum          int                `json:"cpu_num"`
+	LoadAvg         *utils.LoadAvg     `json:"loadavg"`
+	Triggered       bool               `json:"triggered"`
+	TriggeredBy     string             `json:"triggered_by"`
+}
+
+func (context *LoadAvgContext) check() error {
+	for _, thresholds := range []map[string]float64{context.Thresholds, context.ClearThresholds} {
+		for entry := range thresholds {
+			if !common.Contains(LoadAvgEntries, entry) {
+				return fmt.Errorf("Unrecognized loadavg entry [%s]", entry)
+			}
+		}
+	}
+	return nil
+}
+
+func (context *LoadAvgContext) Fill(param, paramOverride *[]byte) error {
+	if err := common.FillContext(param, paramOverride, context); err != nil {
+		return err
+	}
+	return context.check()
+}
+
+func (context *LoadAvgContext) IsTriggered() bool {
+	return context.Triggered
+}
+
+func (context *LoadAvgContext) IsCleared() (bool, bool) {
+	if len(context.ClearThresholds) == 0 {
+		return false, false
+	}
+	if context.LoadAvg == nil {
+		return false, true
+	}
+	perCpu := context.LoadAvg.PerCpu(context.CpuNum)
+	for entry, threshold := range context.ClearThresholds {
+		if perCpu.GetEntry(entry) >= threshold {
+			return false, true
+		}
+	}
+	return true, true
+}
+
+type TaskLoadAvgInstance struct{}
+
+func NewLoadAvgInstance(_ common.TaskType) (TaskInstance, error) {
+	return &TaskLoadAvgInstance{}, nil
+}
+
+func (instance *TaskLoadAvgInstance) isBeyondExpectation(context *LoadAvgContext) string {
+	perCpu := context.LoadAvg.PerCpu(context.CpuNum)
+	for _, entry := range LoadAvgEntries {
+		threshold, isExist := context.Thresholds[entry]
+		if isExist && perCpu.GetEntry(entry) >= threshold {
+			return entry
+		}
+	}
+	return ""
+}
+
+func (instance *TaskLoadAvgInstance) writeToFile(context *LoadAvgContext, path string) error {
+	bytes, err := json.Marshal(*context)
+	if err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	if _, err = fp.WriteString(string(bytes)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (instance *TaskLoadAvgInstance) GetLogDataPathPostfix(instContext interface{}) string {
+	return ".loadavg"
+}
+
+func (instance *TaskLoadAvgInstance) Process(_ context.Context, instContext interface{}, logPathManager log.LogPathManager, result chan error) {
+	loadAvgContext := instContext.(*LoadAvgContext)
+	var err error
+	defer func() {
+		result <- err
+	}()
+
+	loadAvgContext.CpuNum = utils.GetCpuNum()
+	loadAvgContext.LoadAvg, err = utils.GetLoadAvg()
+	if err != nil {
+		logrus.Errorf("Failed to get loadavg, err [%s]", err)
+		return
+	}
+
+	loadAvgContext.TriggeredBy = instance.isBeyondExpectation(loadAvgContext)
+	loadAvgContext.Triggered = loadAvgContext.TriggeredBy != ""
+	if loadAvgContext.Triggered {
+		err = nil
+	} else {
+		err = notTriggered("LoadAvg value does not exceed thresholds")
+	}
+
+	logDataPath := logPathManager.DataPath(".loadavg")
+	if err := instance.writeToFile(loadAvgContext, logDataPath); err != nil {
+		logrus.Errorf("Failed to write to file [%s], err [%s]", logDataPath, err)
+	}
+}
@@ -1,8 +1,8 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"os"
 
 	"hermes/backend/utils"
@@ -15,15 +15,46 @@ import (
 const MemTotal = "MemTotal"
 
 type MemoryInfoContext struct {
-	Thresholds map[string]int64
-	MemInfo    *utils.MemInfo
-	Triggered  bool
+	TriggerOptions  `yaml:",inline"`
+	Thresholds      map[string]int64
+	ClearThresholds map[string]int64 `yaml:"clear_thresholds,omitempty" json:",omitempty"`
+	MemInfo         *utils.MemInfo
+	Triggered       bool
 }
 
 func (context *MemoryInfoContext) Fill(param, paramOverride *[]byte) error {
 	return common.FillContext(param, paramOverride, context)
 }
 
+func (context *MemoryInfoContext) IsTriggered() bool {
+	return context.Triggered
+}
+
+// IsCleared reports whether every entry is back above its clear percent.
+func (context *MemoryInfoContext) IsCleared() (bool, bool) {
+	if len(context.ClearThresholds) == 0 {
+		return false, false
+	}
+	if context.MemInfo == nil {
+		return false, true
+	}
+	memTotal, isExist := (*context.MemInfo)[MemTotal]
+	if !isExist {
+		return false, true
+	}
+
+	for entry, percent := range context.ClearThresholds {
+		val, isExist := (*context.MemInfo)[entry]
+		if !isExist {
+			continue
+		}
+		if val <= memTotal*percent/100 {
+			return false, true
+		}
+	}
+	return true, true
+}
+
 type TaskMemoryInfoInstance struct{}
 
 func NewMemoryInfoInstance(_ common.TaskType) (TaskInstance, error) {
@@ -70,7 +101,7 @@ func (instance *TaskMemoryInfoInstance) GetLogDataPathPostfix(instContext interf
 	return ".meminfo"
 }
 
-func (instance *TaskMemoryInfoInstance) Process(instContext interface{}, logPathManager log.LogPathManager, result chan error) {
+func (instance *TaskMemoryInfoInstance) Process(_ context.Context, instContext interface{}, logPathManager log.LogPathManager, result chan error) {
 	memoryInfoContext := instContext.(*MemoryInfoContext)
 	var err error
 	defer func() {
@@ -87,7 +118,7 @@ func (instance *TaskMemoryInfoInstance) Process(instContext interface{}, logPath
 	if memoryInfoContext.Triggered {
 		err = nil
 	} else {
-		err = fmt.Errorf("MemInfo value does not exceed thresholds")
+		err = notTriggered("MemInfo value does not exceed thresholds")
 	}
 
 	logDataPath := logPathManager.DataPath(".meminfo")
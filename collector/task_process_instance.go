@@ -0,0 +1,275 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"hermes/backend/utils"
+	"hermes/common"
+	"hermes/log"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ProcessTarget selects the processes to check, the selectors are combined.
+// Comm is a regular expression, Unit is a systemd unit and Cgroup is a cgroup
+// v2 path, absolute or relative to the cgroup mount.
+type ProcessTarget struct {
+	Comm    string `yaml:"comm,omitempty" json:"comm,omitempty"`
+	PidFile string `yaml:"pid_file,omitempty" json:"pid_file,omitempty"`
+	Unit    string `yaml:"unit,omitempty" json:"unit,omitempty"`
+	Cgroup  string `yaml:"cgroup,omitempty" json:"cgroup,omitempty"`
+}
+
+// ProcessThresholds are checked per process, except the pressures which
+// are the some/avg10 of the selected cgroup. A zero value is not checked.
+type ProcessThresholds struct {
+	CpuPercent     float64 `yaml:"cpu_percent,omitempty" json:"cpu_percent,omitempty"`
+	RssMB          uint64  `yaml:"rss_mb,omitempty" json:"rss_mb,omitempty"`
+	Threads        int32   `yaml:"threads,omitempty" json:"threads,omitempty"`
+	Fds            int32   `yaml:"fds,omitempty" json:"fds,omitempty"`
+	CpuPressure    float64 `yaml:"cpu_pressure,omitempty" json:"cpu_pressure,omitempty"`
+	MemoryPressure float64 `yaml:"memory_pressure,omitempty" json:"memory_pressure,omitempty"`
+	IOPressure     float64 `yaml:"io_pressure,omitempty" json:"io_pressure,omitempty"`
+}
+
+type ProcessContext struct {
+	TriggerOptions `yaml:",inline"`
+	Target         ProcessTarget                   `json:"target"`
+	Thresholds     ProcessThresholds               `json:"thresholds"`
+	Interval       uint32                          `yaml:"interval" json:"interval"`
+	CgroupPath     string                          `json:"cgroup_path,omitempty"`
+	Samples        []utils.ProcessSample           `json:"samples"`
+	Pressures      map[utils.PSIType]utils.PSIAvgs `json:"pressures,omitempty"`
+	Triggered      bool                            `json:"triggered"`
+	TriggeredBy    string                          `json:"triggered_by"`
+	Targets        Targets                         `json:"targets"`
+}
+
+func (context *ProcessContext) check() error {
+	target := &context.Target
+	if target.Comm == "" && target.PidFile == "" && target.Unit == "" && target.Cgroup == "" {
+		return fmt.Errorf("The target cannot be empty")
+	}
+	if target.Unit != "" && target.Cgroup != "" {
+		return fmt.Errorf("The unit and the cgroup cannot be both set")
+	}
+	if context.Interval == 0 {
+		context.Interval = 1
+	}
+	return nil
+}
+
+func (context *ProcessContext) Fill(param, paramOverride *[]byte) error {
+	if err := common.FillContext(param, paramOverride, context); err != nil {
+		return err
+	}
+	return context.check()
+}
+
+func (context *ProcessContext) IsTriggered() bool {
+	return context.Triggered
+}
+
+func (context *ProcessContext) IsCleared() (bool, bool) {
+	return false, false
+}
+
+func (context *ProcessContext) GetTargets() *Targets {
+	return &context.Targets
+}
+
+type TaskProcessInstance struct{}
+
+func NewProcessInstance(_ common.TaskType) (TaskInstance, error) {
+	return &TaskProcessInstance{}, nil
+}
+
+func (instance *TaskProcessInstance) getPids(ctx context.Context, processContext *ProcessContext) ([]int32, error) {
+	target := &processContext.Target
+	var pids []int32
+	var err error
+
+	if target.Unit != "" {
+		if processContext.CgroupPath, err = utils.GetUnitCgroup(ctx, target.Unit); err != nil {
+			return nil, err
+		}
+	} else if target.Cgroup != "" {
+		processContext.CgroupPath = utils.GetCgroupPath(target.Cgroup)
+	}
+	if processContext.CgroupPath != "" {
+		if pids, err = utils.GetCgroupTreePids(processContext.CgroupPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if target.PidFile != "" {
+		pid, err := utils.ReadPidFile(target.PidFile)
+		if err != nil {
+			return nil, err
+		}
+		if processContext.CgroupPath != "" && !common.Contains(pids, pid) {
+			return nil, nil
+		}
+		pids = []int32{pid}
+	}
+
+	if target.Comm != "" {
+		commPids, err := utils.FindPidsByComm(target.Comm)
+		if err != nil {
+			return nil, err
+		}
+		if processContext.CgroupPath == "" && target.PidFile == "" {
+			return commPids, nil
+		}
+		var ret []int32
+		for _, pid := range pids {
+			if common.Contains(commPids, pid) {
+				ret = append(ret, pid)
+			}
+		}
+		pids = ret
+	}
+	return pids, nil
+}
+
+func (instance *TaskProcessInstance) sample(ctx context.Context, processContext *ProcessContext, pids []int32) error {
+	var samples []*utils.ProcessSample
+	for _, pid := range pids {
+		sample, err := utils.NewProcessSample(pid)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+
+	interval := time.Duration(processContext.Interval) * time.Second
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(interval):
+	}
+
+	for _, sample := range samples {
+		// the process may have exited in between
+		if err := sample.Update(interval.Seconds()); err != nil {
+			continue
+		}
+		processContext.Samples = append(processContext.Samples, *sample)
+	}
+	return nil
+}
+
+func (instance *TaskProcessInstance) checkSample(thresholds *ProcessThresholds, sample *utils.ProcessSample) string {
+	if thresholds.CpuPercent != 0 && sample.CpuPercent >= thresholds.CpuPercent {
+		return "cpu_percent"
+	} else if thresholds.RssMB != 0 && sample.RssMB >= thresholds.RssMB {
+		return "rss_mb"
+	} else if thresholds.Threads != 0 && sample.Threads >= thresholds.Threads {
+		return "threads"
+	} else if thresholds.Fds != 0 && sample.Fds >= thresholds.Fds {
+		return "fds"
+	}
+	return ""
+}
+
+func (instance *TaskProcessInstance) checkPressures(processContext *ProcessContext) (string, error) {
+	thresholds := map[utils.PSIType]float64{
+		utils.CpuPSI:    processContext.Thresholds.CpuPressure,
+		utils.MemoryPSI: processContext.Thresholds.MemoryPressure,
+		utils.IOPSI:     processContext.Thresholds.IOPressure,
+	}
+
+	var psi utils.PSI
+	var triggeredBy string
+	processContext.Pressures = make(map[utils.PSIType]utils.PSIAvgs)
+	for _, psiType := range []utils.PSIType{utils.CpuPSI, utils.MemoryPSI, utils.IOPSI} {
+		if thresholds[psiType] == 0 {
+			continue
+		}
+		levels, err := psi.GetCgroupLevel(processContext.CgroupPath, psiType)
+		if err != nil {
+			return "", err
+		}
+		processContext.Pressures[psiType] = levels.Some
+		if triggeredBy == "" && levels.Some.Avg10 >= thresholds[psiType] {
+			triggeredBy = string(psiType) + utils.PressurePostfix
+		}
+	}
+	return triggeredBy, nil
+}
+
+func (instance *TaskProcessInstance) writeToFile(context *ProcessContext, path string) error {
+	bytes, err := json.Marshal(*context)
+	if err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	if _, err = fp.WriteString(string(bytes)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (instance *TaskProcessInstance) GetLogDataPathPostfix(instContext interface{}) string {
+	return ".process"
+}
+
+func (instance *TaskProcessInstance) Process(ctx context.Context, instContext interface{}, logPathManager log.LogPathManager, result chan error) {
+	processContext := instContext.(*ProcessContext)
+	var err error
+	defer func() {
+		result <- err
+	}()
+
+	pids, err := instance.getPids(ctx, processContext)
+	if err != nil {
+		logrus.Errorf("Failed to get target processes, err [%s]", err)
+		return
+	}
+	if err = instance.sample(ctx, processContext, pids); err != nil {
+		return
+	}
+
+	for i := range processContext.Samples {
+		sample := &processContext.Samples[i]
+		if by := instance.checkSample(&processContext.Thresholds, sample); by != "" {
+			if processContext.TriggeredBy == "" {
+				processContext.TriggeredBy = fmt.Sprintf("%d/%s", sample.Pid, by)
+			}
+			processContext.Targets.Pids = append(processContext.Targets.Pids, sample.Pid)
+		}
+	}
+	if processContext.CgroupPath != "" {
+		by, err := instance.checkPressures(processContext)
+		if err != nil {
+			logrus.Errorf("Failed to get pressure of cgroup [%s], err [%s]", processContext.CgroupPath, err)
+		} else if by != "" {
+			if processContext.TriggeredBy == "" {
+				processContext.TriggeredBy = by
+			}
+			// the whole cgroup misbehaves
+			processContext.Targets.Pids = pids
+		}
+	}
+	processContext.Triggered = processContext.TriggeredBy != ""
+	if processContext.Triggered {
+		processContext.Targets.Cgroup = processContext.CgroupPath
+		err = nil
+	} else {
+		err = notTriggered("Process value does not exceed thresholds")
+	}
+
+	logDataPath := logPathManager.DataPath(".process")
+	if err := instance.writeToFile(processContext, logDataPath); err != nil {
+		logrus.Errorf("Failed to write to file [%s], err [%s]", logDataPath, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"sync"
 	"time"
@@ -16,6 +17,7 @@ import (
 	"hermes/log"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -23,10 +25,33 @@ const (
 	SamplePeriod = "period"
 )
 
+// JitFileDir is where the JIT runtimes write perf-<pid>.map and
+// jit-<pid>.dump.
+const JitFileDir = "/tmp"
+
+// ProfileContext samples the whole host unless targets are given: Pids and
+// the processes whose comm matches Comm, or the tasks of the cgroup v2 path
+// Cgroup. Without them, the targets selected by the job's conditions are
+// used if any. The records are written in binary unless RecordFormat is
+// json for debugging, Compress gzips the binary ones. PerfData merges the
+// binary records into a perf.data file for the perf tools.
 type ProfileContext struct {
-	Timeout      uint32 `yaml:"timeout"`
-	SamplingType string `yaml:"sampling_type"`
-	Sampling     uint64 `yaml:"sampling"`
+	Timeout      uint32  `yaml:"timeout"`
+	SamplingType string  `yaml:"sampling_type"`
+	Sampling     uint64  `yaml:"sampling"`
+	Pids         []int32 `yaml:"pids"`
+	Comm         string  `yaml:"comm"`
+	Cgroup       string  `yaml:"cgroup"`
+	RecordFormat string  `yaml:"record_format"`
+	Compress     bool    `yaml:"compress"`
+	PerfData     bool    `yaml:"perf_data"`
+}
+
+func (context *ProfileContext) getWriterOptions() perf.WriterOptions {
+	return perf.WriterOptions{
+		Format:   perf.RecordFormat(context.RecordFormat),
+		Compress: context.Compress,
+	}
 }
 
 func (context *ProfileContext) check() error {
@@ -39,6 +64,21 @@ func (context *ProfileContext) check() error {
 	if context.Sampling == 0 {
 		return fmt.Errorf("The sampling cannot be zero")
 	}
+	if context.Comm != "" {
+		if _, err := regexp.Compile(context.Comm); err != nil {
+			return fmt.Errorf("Failed to compile comm [%s], err [%s]", context.Comm, err)
+		}
+	}
+	if context.Cgroup != "" && (len(context.Pids) != 0 || context.Comm != "") {
+		return fmt.Errorf("The cgroup cannot be combined with pids or comm")
+	}
+	options := context.getWriterOptions()
+	if err := options.Check(); err != nil {
+		return err
+	}
+	if context.PerfData && options.Format == perf.JsonFormat {
+		return fmt.Errorf("The perf data cannot be written from JSON records")
+	}
 	return nil
 }
 
@@ -59,17 +99,145 @@ func (instance *TaskProfileInstance) GetLogDataPathPostfix(instContext interface
 	return ".perf.*"
 }
 
-func (instance *TaskProfileInstance) profile(ctx context.Context, cpu int, attr *perf.Attr, logDataPath string) {
-	perfEvent, err := perf.NewPerfEvent(attr, perf.AllThreads, cpu)
+// getTargets returns nil if the whole host should be profiled, a cgroup
+// target takes precedence over the pids.
+func (instance *TaskProfileInstance) getTargets(ctx context.Context, profileContext *ProfileContext) (*Targets, error) {
+	var targets *Targets
+	if profileContext.Cgroup != "" {
+		targets = &Targets{Cgroup: utils.GetCgroupPath(profileContext.Cgroup)}
+	} else if len(profileContext.Pids) != 0 || profileContext.Comm != "" {
+		targets = &Targets{Pids: profileContext.Pids}
+		if profileContext.Comm != "" {
+			pids, err := utils.FindPidsByComm(profileContext.Comm)
+			if err != nil {
+				return nil, err
+			}
+			targets.merge(&Targets{Pids: pids})
+		}
+	} else if targets = GetTargets(ctx); targets == nil || targets.IsEmpty() {
+		return nil, nil
+	}
+
+	if targets.Cgroup != "" {
+		return &Targets{Cgroup: targets.Cgroup}, nil
+	}
+	if len(targets.Pids) == 0 {
+		return nil, fmt.Errorf("No process matches the targets")
+	}
+	return targets, nil
+}
+
+func (instance *TaskProfileInstance) synthesize(targets *Targets, path string, options perf.WriterOptions, buildIDs *dbgsym.BuildIDCollector) error {
+	synthesizeEvents, err := perf.NewSynthesizeEvents(path, options)
+	if err != nil {
+		return err
+	}
+	defer synthesizeEvents.Close()
+	synthesizeEvents.SetBuildIDCollector(buildIDs)
+	if targets == nil {
+		return synthesizeEvents.Synthesize()
+	}
+
+	pids := targets.Pids
+	if targets.Cgroup != "" {
+		if pids, err = utils.GetCgroupTreePids(targets.Cgroup); err != nil {
+			return err
+		}
+	}
+	return synthesizeEvents.SynthesizePids(pids)
+}
+
+// getJitFiles returns the perf-map and jitdump files of the processes,
+// the ones in containers are under their root with the pid of their
+// namespace.
+func (instance *TaskProfileInstance) getJitFiles(pid int32) (string, string) {
+	perfMap, jitDump := "", ""
+	paths := []string{
+		filepath.Join(JitFileDir, fmt.Sprintf("perf-%d.map", pid)),
+		filepath.Join(JitFileDir, fmt.Sprintf("jit-%d.dump", pid)),
+	}
+	if nsPid, err := utils.GetNsPid(pid); err == nil && nsPid != pid {
+		root := fmt.Sprintf("/proc/%d/root", pid)
+		paths = append(paths,
+			filepath.Join(root, JitFileDir, fmt.Sprintf("perf-%d.map", nsPid)),
+			filepath.Join(root, JitFileDir, fmt.Sprintf("jit-%d.dump", nsPid)))
+	}
+	for i, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if i%2 == 0 && perfMap == "" {
+			perfMap = path
+		} else if i%2 == 1 && jitDump == "" {
+			jitDump = path
+		}
+	}
+	return perfMap, jitDump
+}
+
+func (instance *TaskProfileInstance) getJitPids(targets *Targets) ([]int32, error) {
+	if targets == nil {
+		// only the host processes are found without a target
+		var pids []int32
+		matches, err := filepath.Glob(filepath.Join(JitFileDir, "*-*.*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			var pid int32
+			name := filepath.Base(match)
+			if _, err := fmt.Sscanf(name, "perf-%d.map", &pid); err != nil {
+				if _, err := fmt.Sscanf(name, "jit-%d.dump", &pid); err != nil {
+					continue
+				}
+			}
+			pids = append(pids, pid)
+		}
+		return pids, nil
+	}
+	if targets.Cgroup != "" {
+		return utils.GetCgroupTreePids(targets.Cgroup)
+	}
+	return targets.Pids, nil
+}
+
+// snapshotJitFiles copies the perf-map and jitdump files written by the JIT
+// runtimes into the log data directory.
+func (instance *TaskProfileInstance) snapshotJitFiles(targets *Targets, logPathManager log.LogPathManager) {
+	pids, err := instance.getJitPids(targets)
+	if err != nil {
+		logrus.Errorf("Failed to get processes with JIT files, err [%s]", err)
+		return
+	}
+
+	for _, pid := range pids {
+		perfMap, jitDump := instance.getJitFiles(pid)
+		if perfMap != "" {
+			if err := common.CopyFile(perfMap, logPathManager.DataPath(perf.PerfMapPostfix+strconv.Itoa(int(pid)))); err != nil {
+				logrus.Errorf("Failed to snapshot [%s], err [%s]", perfMap, err)
+			}
+		}
+		if jitDump != "" {
+			if err := common.CopyFile(jitDump, logPathManager.DataPath(perf.JitDumpPostfix+strconv.Itoa(int(pid)))); err != nil {
+				logrus.Errorf("Failed to snapshot [%s], err [%s]", jitDump, err)
+			}
+		}
+	}
+}
+
+func (instance *TaskProfileInstance) profile(ctx context.Context, open func() (*perf.PerfEvent, error), logDataPath string, options perf.WriterOptions, buildIDs *dbgsym.BuildIDCollector) {
+	perfEvent, err := open()
 	if err != nil {
 		logrus.Error(err)
 		return
 	}
+	defer perfEvent.Release()
+	perfEvent.SetBuildIDCollector(buildIDs)
 
-	perfEvent.Profile(ctx, logDataPath)
+	perfEvent.Profile(ctx, logDataPath, options)
 }
 
-func (instance *TaskProfileInstance) Process(instContext interface{}, logPathManager log.LogPathManager, result chan error) {
+func (instance *TaskProfileInstance) Process(ctx context.Context, instContext interface{}, logPathManager log.LogPathManager, result chan error) {
 	profileContext := instContext.(*ProfileContext)
 	var err error
 	defer func() {
@@ -93,36 +261,95 @@ func (instance *TaskProfileInstance) Process(instContext interface{}, logPathMan
 		attr.SetSamplePeriod(profileContext.Sampling)
 	}
 	attr.SetWakeupEvents(1)
+	if profileContext.PerfData {
+		// perf report takes the leaf from the ip and orders by the time
+		attr.SampleFormat.IP = true
+		attr.SampleFormat.Time = true
+	}
+
+	options := profileContext.getWriterOptions()
+	targets, err := instance.getTargets(ctx, profileContext)
+	if err != nil {
+		logrus.Errorf("Failed to get profile targets, err [%s]", err)
+		return
+	}
 
-	if synthesizeEvents, err := perf.NewSynthesizeEvents(logPathManager.DataPath(".perf.synth_events")); err != nil {
-		logrus.Errorf("Failed to generate object for synthesizing events, err [%s]", err)
-	} else if err := synthesizeEvents.Synthesize(); err != nil {
+	// the build IDs are read while the files are mapped, the parser finds
+	// the binaries by them
+	buildIDs := dbgsym.NewBuildIDCollector(logPathManager.DbgsymPath())
+	synthPath := logPathManager.DataPath(".perf.synth_events")
+	recordPaths := []string{synthPath}
+	if err := instance.synthesize(targets, synthPath, options, buildIDs); err != nil {
 		logrus.Errorf("Failed to synthesize events, err [%s]", err)
 	}
-	buildID := dbgsym.NewBuildID(dbgsym.KernelMode, "", logPathManager.DbgsymPath())
-	if _buildID, err := buildID.Build(); err != nil {
-		logrus.Errorf("Failed to get kernel's build ID, err [%s]", err)
-	} else {
-		kernSymPath := logPathManager.DataPath(".perf.kern.sym")
-		dbgKernelPath := buildID.GetKernelPath(_buildID)
-		if relPath, err := filepath.Rel(filepath.Dir(kernSymPath), dbgKernelPath); err != nil {
-			logrus.Errorf("Failed to get a relative path of [%s], [%s], err [%s]", kernSymPath, dbgKernelPath, err)
-		} else if err := os.Symlink(relPath, kernSymPath); err != nil {
-			logrus.Errorf("Failed to create a symlink [%s], target [%s], err [%s]", kernSymPath, relPath, err)
-		}
+	if _, err := dbgsym.SnapshotKernel(logPathManager.DataPath(".perf.kern.sym")); err != nil {
+		logrus.Errorf("Failed to snapshot kernel symbols, err [%s]", err)
+	}
+	// the address ranges of the modules symbolized by kallsyms
+	if err := common.CopyFile("/proc/modules", logPathManager.DataPath(".perf.kern.modules")); err != nil {
+		logrus.Errorf("Failed to snapshot kernel modules, err [%s]", err)
 	}
 
 	var waitGroup sync.WaitGroup
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(profileContext.Timeout)*time.Second)
+	profileCtx, cancel := context.WithTimeout(ctx, time.Duration(profileContext.Timeout)*time.Second)
 	defer cancel()
+	cgroupFd := -1
+	var tids []int
+	if targets != nil && targets.Cgroup != "" {
+		if cgroupFd, err = unix.Open(targets.Cgroup, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0); err != nil {
+			logrus.Errorf("Failed to open cgroup [%s], err [%s]", targets.Cgroup, err)
+			return
+		}
+		defer unix.Close(cgroupFd)
+	} else if targets != nil {
+		// the threads share a ring buffer per cpu, the ones created while
+		// profiling are followed by inheriting
+		for _, pid := range targets.Pids {
+			_tids, err := utils.GetTids(pid)
+			if err != nil {
+				logrus.Errorf("Failed to get threads of process [%d], err [%s]", pid, err)
+				continue
+			}
+			for _, tid := range _tids {
+				tids = append(tids, int(tid))
+			}
+		}
+		if len(tids) == 0 {
+			err = fmt.Errorf("No thread of the target processes exists")
+			logrus.Error(err)
+			return
+		}
+	}
 	for cpu := 0; cpu < utils.GetCpuNum(); cpu++ {
 		waitGroup.Add(1)
 		logDataPath := logPathManager.DataPath(".perf.cpu_" + strconv.Itoa(cpu))
+		recordPaths = append(recordPaths, logDataPath)
 		go func(cpu int, path string) {
 			defer waitGroup.Done()
-			instance.profile(ctx, cpu, &attr, path)
+			instance.profile(profileCtx, func() (*perf.PerfEvent, error) {
+				if cgroupFd >= 0 {
+					return perf.NewPerfCgroupEvent(&attr, cgroupFd, cpu)
+				} else if tids != nil {
+					return perf.NewPerfTaskEvent(&attr, tids, cpu)
+				}
+				return perf.NewPerfEvent(&attr, perf.AllThreads, cpu)
+			}, path, options, buildIDs)
 		}(cpu, logDataPath)
 	}
 
 	waitGroup.Wait()
+	buildIDs.Close()
+	buildIDsPath := logPathManager.DataPath(perf.BuildIDsPostfix)
+	if err := buildIDs.WriteToFile(buildIDsPath); err != nil {
+		logrus.Errorf("Failed to write build IDs [%s], err [%s]", buildIDsPath, err)
+	}
+	// the JIT runtimes keep appending, snapshot them after profiling
+	instance.snapshotJitFiles(targets, logPathManager)
+	if profileContext.PerfData {
+		perfDataPath := logPathManager.DataPath(perf.PerfDataPostfix)
+		if err := perf.WritePerfData(perfDataPath, &attr, recordPaths, buildIDs.GetMappedFiles()); err != nil {
+			logrus.Errorf("Failed to write perf data [%s], err [%s]", perfDataPath, err)
+		}
+	}
+	err = ctx.Err()
 }
@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -14,12 +15,30 @@ import (
 
 type PSIThresholds utils.PSIResult
 
+// PSIClearAvgs are the clear thresholds of a bucket, the intervals left out
+// are not compared.
+type PSIClearAvgs struct {
+	Avg10  *float64 `json:"avg10,omitempty"`
+	Avg60  *float64 `json:"avg60,omitempty"`
+	Avg300 *float64 `json:"avg300,omitempty"`
+}
+
+// PSIClearThresholds clears once the configured buckets are all below their
+// thresholds.
+type PSIClearThresholds struct {
+	Some *PSIClearAvgs `json:"some,omitempty"`
+	Full *PSIClearAvgs `json:"full,omitempty"`
+}
+
 type PSIContext struct {
-	Type        utils.PSIType    `json:"psi_type"`
-	Thresholds  *PSIThresholds   `json:"thresholds"`
-	Levels      *utils.PSIResult `json:"levels"`
-	Triggered   bool             `json:"triggered"`
-	TriggeredBy string           `json:"triggered_by"`
+	TriggerOptions  `yaml:",inline"`
+	Type            utils.PSIType       `json:"psi_type"`
+	Thresholds      *PSIThresholds      `json:"thresholds"`
+	ClearThresholds *PSIClearThresholds `yaml:"clear_thresholds,omitempty" json:"clear_thresholds,omitempty"`
+	Levels          *utils.PSIResult    `json:"levels"`
+	Triggered       bool                `json:"triggered"`
+	TriggeredBy     string              `json:"triggered_by"`
+	Cleared         bool                `json:"cleared,omitempty"`
 }
 
 func (context *PSIContext) check() error {
@@ -36,6 +55,14 @@ func (context *PSIContext) Fill(param, paramOverride *[]byte) error {
 	return context.check()
 }
 
+func (context *PSIContext) IsTriggered() bool {
+	return context.Triggered
+}
+
+func (context *PSIContext) IsCleared() (bool, bool) {
+	return context.Cleared, context.ClearThresholds != nil
+}
+
 type TaskPSIInstance struct{}
 
 func NewTaskPSIInstance(_ common.TaskType) (TaskInstance, error) {
@@ -45,7 +72,7 @@ func NewTaskPSIInstance(_ common.TaskType) (TaskInstance, error) {
 func (instance *TaskPSIInstance) isBeyondExpectation(psiAvgs *utils.PSIAvgs, expected *utils.PSIAvgs) string {
 	if psiAvgs.Avg10 >= expected.Avg10 {
 		return utils.PSIAvg10
-	} else if psiAvgs.Avg60 >= expected.Avg10 {
+	} else if psiAvgs.Avg60 >= expected.Avg60 {
 		return utils.PSIAvg60
 	} else if psiAvgs.Avg300 >= expected.Avg300 {
 		return utils.PSIAvg300
@@ -53,6 +80,25 @@ func (instance *TaskPSIInstance) isBeyondExpectation(psiAvgs *utils.PSIAvgs, exp
 	return ""
 }
 
+func (instance *TaskPSIInstance) isCleared(psiAvgs *utils.PSIAvgs, expected *PSIClearAvgs) bool {
+	if expected == nil {
+		return true
+	}
+	for _, avg := range []struct {
+		val       float64
+		threshold *float64
+	}{
+		{psiAvgs.Avg10, expected.Avg10},
+		{psiAvgs.Avg60, expected.Avg60},
+		{psiAvgs.Avg300, expected.Avg300},
+	} {
+		if avg.threshold != nil && avg.val >= *avg.threshold {
+			return false
+		}
+	}
+	return true
+}
+
 func (instance *TaskPSIInstance) ToFile(psiContext *PSIContext, logDataPath string) error {
 	bytes, err := json.Marshal(psiContext)
 	if err != nil {
@@ -74,7 +120,7 @@ func (instance *TaskPSIInstance) GetLogDataPathPostfix(instContext interface{})
 	return ".psi"
 }
 
-func (instance *TaskPSIInstance) Process(instContext interface{}, logPathManager log.LogPathManager, result chan error) {
+func (instance *TaskPSIInstance) Process(_ context.Context, instContext interface{}, logPathManager log.LogPathManager, result chan error) {
 	psiContext := instContext.(*PSIContext)
 	var err error
 	defer func() {
@@ -99,6 +145,10 @@ func (instance *TaskPSIInstance) Process(instContext interface{}, logPathManager
 			psiContext.TriggeredBy = utils.PSIFull + "/" + interval
 		}
 	}
+	if psiContext.ClearThresholds != nil {
+		psiContext.Cleared = instance.isCleared(&psiContext.Levels.Some, psiContext.ClearThresholds.Some) &&
+			instance.isCleared(&psiContext.Levels.Full, psiContext.ClearThresholds.Full)
+	}
 	err = instance.ToFile(psiContext, logPathManager.DataPath(".psi"))
 
 	if err != nil {
@@ -109,6 +159,6 @@ func (instance *TaskPSIInstance) Process(instContext interface{}, logPathManager
 	if psiContext.Triggered {
 		err = nil
 	} else {
-		err = fmt.Errorf("PSI value does not exceed threshold")
+		err = notTriggered("PSI value does not exceed threshold")
 	}
 }
@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -49,7 +50,7 @@ func (instance *TaskTraceInstance) GetLogDataPathPostfix(instContext interface{}
 	return ".trace"
 }
 
-func (instance *TaskTraceInstance) Process(instContext interface{}, logPathManager log.LogPathManager, result chan error) {
+func (instance *TaskTraceInstance) Process(ctx context.Context, instContext interface{}, logPathManager log.LogPathManager, result chan error) {
 	traceContext := instContext.(*TraceContext)
 	var err error
 	defer func() {
@@ -73,6 +74,9 @@ func (instance *TaskTraceInstance) Process(instContext interface{}, logPathManag
 	select {
 	case err = <-ack:
 		return
+	case <-ctx.Done():
+		timeout <- true
+		err = ctx.Err()
 	case <-timer.C:
 		timeout <- true
 	}
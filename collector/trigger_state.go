@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// TriggerOptions are shared by the condition tasks. For requires the
+// condition to hold for that many consecutive evaluations before firing,
+// and Cooldown is the minimum number of seconds between two firings.
+type TriggerOptions struct {
+	For      uint32 `yaml:"for,omitempty" json:"for,omitempty"`
+	Cooldown uint32 `yaml:"cooldown,omitempty" json:"cooldown,omitempty"`
+}
+
+func (options *TriggerOptions) GetTriggerOptions() *TriggerOptions {
+	return options
+}
+
+// TriggerContext is implemented by the condition contexts that support
+// trigger options. IsCleared reports whether the sample fell below the clear
+// threshold, isSet is false if no clear threshold is configured. Once fired,
+// a condition with a clear threshold does not fire again until cleared.
+type TriggerContext interface {
+	GetTriggerOptions() *TriggerOptions
+	IsTriggered() bool
+	IsCleared() (cleared, isSet bool)
+}
+
+// CursorContext is implemented by the condition contexts reading a log. The
+// cursor of the last record read is kept across the runs of the job, so that
+// a record is only matched once.
+type CursorContext interface {
+	SetCursor(cursor uint64)
+	GetCursor() (cursor uint64, isSet bool)
+}
+
+type triggerState struct {
+	hits      uint32
+	active    bool
+	lastFired time.Time
+	cursor    uint64
+	hasCursor bool
+}
+
+func (state *triggerState) update(triggerContext TriggerContext, now time.Time) bool {
+	options := triggerContext.GetTriggerOptions()
+	if triggerContext.IsTriggered() {
+		state.hits++
+	} else {
+		state.hits = 0
+	}
+
+	cleared, hasClear := triggerContext.IsCleared()
+	if hasClear && state.active {
+		if !cleared {
+			return false
+		}
+		state.active = false
+	}
+
+	if state.hits == 0 || state.hits < options.For {
+		return false
+	}
+	if options.Cooldown != 0 && !state.lastFired.IsZero() &&
+		now.Sub(state.lastFired) < time.Duration(options.Cooldown)*time.Second {
+		return false
+	}
+
+	state.hits = 0
+	state.active = hasClear
+	state.lastFired = now
+	return true
+}
+
+// TriggerStates keeps the condition state of every job across its runs.
+type TriggerStates struct {
+	mutex  sync.Mutex
+	states map[string]map[string]*triggerState
+}
+
+func NewTriggerStates() *TriggerStates {
+	return &TriggerStates{
+		states: make(map[string]map[string]*triggerState),
+	}
+}
+
+func (states *TriggerStates) Remove(jobName string) {
+	states.mutex.Lock()
+	defer states.mutex.Unlock()
+	delete(states.states, jobName)
+}
+
+func (states *TriggerStates) Scope(jobName, routineName string) *TriggerScope {
+	return &TriggerScope{
+		states:  states,
+		jobName: jobName,
+		prefix:  routineName + "/",
+	}
+}
+
+type TriggerScope struct {
+	states  *TriggerStates
+	jobName string
+	prefix  string
+}
+
+// getState must be called with the mutex held.
+func (scope *TriggerScope) getState(label string) *triggerState {
+	jobStates, isExist := scope.states.states[scope.jobName]
+	if !isExist {
+		jobStates = make(map[string]*triggerState)
+		scope.states.states[scope.jobName] = jobStates
+	}
+	state, isExist := jobStates[scope.prefix+label]
+	if !isExist {
+		state = &triggerState{}
+		jobStates[scope.prefix+label] = state
+	}
+	return state
+}
+
+// update feeds the sample of a condition task into its state and returns
+// whether the condition fires.
+func (scope *TriggerScope) update(label string, triggerContext TriggerContext) bool {
+	scope.states.mutex.Lock()
+	defer scope.states.mutex.Unlock()
+	return scope.getState(label).update(triggerContext, time.Now())
+}
+
+// loadCursor sets the cursor saved by the previous run of the condition task.
+func (scope *TriggerScope) loadCursor(label string, cursorContext CursorContext) {
+	scope.states.mutex.Lock()
+	defer scope.states.mutex.Unlock()
+	if state := scope.getState(label); state.hasCursor {
+		cursorContext.SetCursor(state.cursor)
+	}
+}
+
+func (scope *TriggerScope) saveCursor(label string, cursorContext CursorContext) {
+	scope.states.mutex.Lock()
+	defer scope.states.mutex.Unlock()
+	state := scope.getState(label)
+	state.cursor, state.hasCursor = cursorContext.GetCursor()
+}
@@ -1,12 +1,13 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 )
 
-func PrepareCmd(cmds []string, env map[string]string) *exec.Cmd {
+func PrepareCmd(ctx context.Context, cmds []string, env map[string]string) *exec.Cmd {
 	if len(env) > 0 {
 		for i, cmd := range cmds {
 			if replace, isExist := env[cmd]; isExist {
@@ -15,7 +16,7 @@ func PrepareCmd(cmds []string, env map[string]string) *exec.Cmd {
 		}
 	}
 
-	cmd := exec.Command(cmds[0], cmds[1:]...)
+	cmd := exec.CommandContext(ctx, cmds[0], cmds[1:]...)
 	cmd.Env = os.Environ()
 	for key, val := range env {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, val))
@@ -15,6 +15,9 @@ const (
 	PSI
 	CpuInfo
 	MemoryInfo
+	LoadAvg
+	Process
+	Kmsg
 )
 
 const (
@@ -25,6 +28,9 @@ const (
 	CpuInfoTask    = "cpu_info"
 	MemoryInfoTask = "memory_info"
 	EbpfTask       = "ebpf"
+	LoadAvgTask    = "loadavg"
+	ProcessTask    = "process"
+	KmsgTask       = "kmsg"
 )
 
 type Context interface {
@@ -54,6 +60,9 @@ func TaskNameToType(taskName string) TaskType {
 		PSITask:        PSI,
 		CpuInfoTask:    CpuInfo,
 		MemoryInfoTask: MemoryInfo,
+		LoadAvgTask:    LoadAvg,
+		ProcessTask:    Process,
+		KmsgTask:       Kmsg,
 	}
 
 	taskType, isExist := mapper[taskName]
@@ -5,6 +5,7 @@ import "../css/overview.scss"
 
 const GROUP = 'cpu';
 const ROUTINE = 'cpu_profile';
+const DETAIL_LEVELS = ['function', 'line'];
 
 const Axis = ({ orient, translate, scale, cssClass, tickFormat }) => {
   let axisElement
@@ -157,9 +158,19 @@ const CpuViewChart = ({ margins, dimensions, data, flameGraphHandler, hasFlameGr
 const CpuProfileView = () => {
   const [data, setData] = useState()
   const [flameGraphData, setFlameGraphData] = useState()
+  const [detailLevels, setDetailLevels] = useState()
   const hasFlameGraphData = () => {
     return !!flameGraphData
   }
+  // the line detail is only offered if the parser has written it
+  const showFlameGraph = d => {
+    fetch('/' + GROUP + '/' + ROUTINE + '/' + d.timestamp + '?detail=line', { method: 'HEAD' })
+      .then(resp => resp.ok ? DETAIL_LEVELS : null, () => null)
+      .then(levels => {
+        setDetailLevels(levels)
+        setFlameGraphData(d)
+      })
+  }
 
   useEffect(() => {
     d3.json('/' + GROUP + '/' + ROUTINE).then(data => {
@@ -180,9 +191,9 @@ const CpuProfileView = () => {
   return (
     <div>
       <CpuViewChart className="overview-chart" margins={margins} dimensions={dimensions} data={data}
-        flameGraphHandler={setFlameGraphData} hasFlameGraphData={hasFlameGraphData} />
+        flameGraphHandler={showFlameGraph} hasFlameGraphData={hasFlameGraphData} />
       {flameGraphData && <FlameGraph timestamp={flameGraphData.timestamp} group={GROUP}
-        routine={ROUTINE} closeHandler={() => { setFlameGraphData(null) }} />}
+        routine={ROUTINE} closeHandler={() => { setFlameGraphData(null) }} detailLevels={detailLevels} />}
     </div>
   )
 }
@@ -1,10 +1,11 @@
-import React, { useEffect } from 'react'
+import React, { useEffect, useState } from 'react'
 import * as d3 from 'd3'
 import { flamegraph } from 'd3-flame-graph'
 import "../css/flamegraph.scss"
 import '../../node_modules/d3-flame-graph/dist/d3-flamegraph.css'
 
-const FlameGraph = ({ timestamp, group, routine, closeHandler }) => {
+const FlameGraph = ({ timestamp, group, routine, closeHandler, detailLevels }) => {
+  const [detail, setDetail] = useState(detailLevels ? detailLevels[0] : null)
   const chart = <div id='chart'></div>
   const date = new Date(timestamp * 1000)
   const title = ('0' + (date.getMonth() + 1)).slice(-2) + '/' +
@@ -19,12 +20,14 @@ const FlameGraph = ({ timestamp, group, routine, closeHandler }) => {
     .selfValue(false)
 
   useEffect(() => {
-    d3.json("/" + group + "/" + routine + "/" + timestamp.toString()).then(data => {
+    const query = detail ? "?detail=" + detail : ""
+    d3.json("/" + group + "/" + routine + "/" + timestamp.toString() + query).then(data => {
+      d3.select("#chart").selectAll("*").remove()
       d3.select("#chart")
         .datum(data)
         .call(flameGraph);
     })
-  }, [])
+  }, [detail])
 
   return (
     <div className='box'>
@@ -33,6 +36,9 @@ const FlameGraph = ({ timestamp, group, routine, closeHandler }) => {
       </div>
       <span className='close-icon' onClick={closeHandler}>x</span>
       <button className='reset_zoom' onClick={() => flameGraph.resetZoom()}>Reset zoom</button>
+      {detailLevels && <select className='detail' value={detail} onChange={event => setDetail(event.target.value)}>
+        {detailLevels.map(level => <option key={level} value={level}>{level}</option>)}
+      </select>}
       {chart}
     </div>
   )
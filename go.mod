@@ -12,7 +12,9 @@ require (
 require (
 	github.com/cilium/ebpf v0.11.0
 	github.com/gin-gonic/gin v1.8.1
+	github.com/google/pprof v0.0.0-20230602150820-91b7bce49751
 	github.com/google/uuid v1.3.0
+	github.com/ianlancetaylor/demangle v0.0.0-20260724033716-83e58baca724
 	github.com/joho/godotenv v1.5.1
 	github.com/rivo/tview v0.0.0-20230621164836-6cc0565babaf
 	github.com/shirou/gopsutil v3.21.11+incompatible
@@ -1,13 +1,26 @@
 package log
 
+const (
+	JobCompleted = "completed"
+	JobTimedOut  = "timed_out"
+	JobCancelled = "cancelled"
+	JobSkipped   = "skipped"
+	JobCoalesced = "coalesced"
+	JobDeferred  = "deferred"
+	JobFailed    = "failed"
+)
+
 type Metadata struct {
 	TaskType       int    `yaml:"task_type"`
 	LogDataPostfix string `yaml:"log_data_postfix"`
+	Triggered      *bool  `yaml:"triggered,omitempty"`
 }
 
 type LogMetadata struct {
 	JobName   string     `yaml:"job_name"`
 	DataLabel string     `yaml:"data_label"`
+	Status    string     `yaml:"status,omitempty"`
+	Coalesced uint32     `yaml:"coalesced,omitempty"`
 	Metadatas []Metadata `yaml:"metadatas"`
 }
 
@@ -11,9 +11,10 @@ type LogPathManager struct {
 }
 
 const (
-	LogDataDirName   = "data"
-	LogMetaDirName   = "metadata"
-	LogDbgsymDirName = "dbgsym"
+	LogDataDirName    = "data"
+	LogMetaDirName    = "metadata"
+	LogDbgsymDirName  = "dbgsym"
+	LogHistoryDirName = "history"
 )
 
 func NewLogPathManager(logDir string) *LogPathManager {
@@ -35,6 +36,10 @@ func (inst *LogPathManager) Prepare() error {
 	if err := os.MkdirAll(logDbgsymDir, os.ModePerm); err != nil {
 		return err
 	}
+	logHistoryDir := filepath.Join(inst.logDir, LogHistoryDirName)
+	if err := os.MkdirAll(logHistoryDir, os.ModePerm); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -54,3 +59,7 @@ func (inst *LogPathManager) MetadataPath() string {
 func (inst *LogPathManager) DbgsymPath() string {
 	return filepath.Join(inst.logDir, LogDbgsymDirName)
 }
+
+func (inst *LogPathManager) HistoryPath() string {
+	return filepath.Join(inst.logDir, LogHistoryDirName)
+}
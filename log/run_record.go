@@ -0,0 +1,34 @@
+package log
+
+type CondOutcome struct {
+	Routine   string `yaml:"routine"`
+	Triggered bool   `yaml:"triggered"`
+}
+
+type RunRecord struct {
+	JobName    string        `yaml:"job_name"`
+	DataLabel  string        `yaml:"data_label"`
+	StartTime  int64         `yaml:"start_time"`
+	EndTime    int64         `yaml:"end_time"`
+	Routines   []string      `yaml:"routines,omitempty"`
+	Conditions []CondOutcome `yaml:"conditions,omitempty"`
+	Status     string        `yaml:"status"`
+	Error      string        `yaml:"error,omitempty"`
+}
+
+func (record *RunRecord) AddCondOutcome(routine string, triggered bool) {
+	record.Conditions = append(record.Conditions, CondOutcome{
+		Routine:   routine,
+		Triggered: triggered,
+	})
+}
+
+// IsExecuted returns whether the job ran, the skipped, coalesced and
+// deferred runs are only recorded.
+func (record *RunRecord) IsExecuted() bool {
+	switch record.Status {
+	case JobSkipped, JobCoalesced, JobDeferred:
+		return false
+	}
+	return true
+}
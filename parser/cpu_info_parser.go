@@ -18,7 +18,7 @@ type CpuInfoRecord struct {
 
 type CpuInfoParser struct{}
 
-func GetCpuInfoParser() (ParserInstance, error) {
+func GetCpuInfoParser(_ Options) (ParserInstance, error) {
 	return &CpuInfoParser{}, nil
 }
 
@@ -1,7 +1,8 @@
 package parser
 
 import (
-	"bufio"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -9,35 +10,77 @@ import (
 	"strings"
 
 	"hermes/backend/perf"
+	"hermes/backend/symbol"
+	"hermes/backend/utils"
 	"hermes/log"
+
+	"github.com/sirupsen/logrus"
 )
 
-type CpuProfileParser struct{}
+const CpuPprofFile = "overall_cpu.pb.gz"
+
+type CpuProfileParser struct {
+	rawSymbols        bool
+	lineDetail        bool
+	flameGraphFormats []utils.FlameGraphFormat
+}
 
-func GetCpuProfileParser() (ParserInstance, error) {
-	return &CpuProfileParser{}, nil
+func GetCpuProfileParser(options Options) (ParserInstance, error) {
+	return &CpuProfileParser{
+		rawSymbols:        options.RawSymbols,
+		lineDetail:        options.LineDetail,
+		flameGraphFormats: options.FlameGraphFormats,
+	}, nil
 }
 
 func (parser *CpuProfileParser) parseStackCollapsedData(logPath string, recordHandler *perf.RecordHandler) error {
-	fp, err := os.Open(logPath)
+	reader, err := perf.NewRecordReader(logPath)
 	if err != nil {
 		return err
 	}
-	defer fp.Close()
+	defer reader.Close()
+
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if errors.Is(err, io.ErrUnexpectedEOF) {
+			// the collector was stopped while writing the records
+			logrus.Warnf("The records of [%s] are truncated", logPath)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := recordHandler.Handle(rec); err != nil {
+			return err
+		}
+	}
+}
 
-	scanner := bufio.NewScanner(fp)
+func (parser *CpuProfileParser) isJitFile(filePath string) bool {
+	return strings.Contains(filePath, perf.PerfMapPostfix) || strings.Contains(filePath, perf.JitDumpPostfix)
+}
 
-	for scanner.Scan() {
-		bytes := scanner.Bytes()
-		if err := recordHandler.Parse(bytes); err != nil {
+func (parser *CpuProfileParser) loadJitSymbols(filePath string, recordHandler *perf.RecordHandler) error {
+	for _, postfix := range []string{perf.PerfMapPostfix, perf.JitDumpPostfix} {
+		idx := strings.LastIndex(filePath, postfix)
+		if idx < 0 {
+			continue
+		}
+		pid, err := strconv.ParseUint(filePath[idx+len(postfix):], 10, 32)
+		if err != nil {
 			return err
 		}
+		if postfix == perf.PerfMapPostfix {
+			return recordHandler.LoadPerfMap(uint32(pid), filePath)
+		}
+		return recordHandler.LoadJitDump(uint32(pid), filePath)
 	}
 	return nil
 }
 
 func (parser *CpuProfileParser) Parse(logPathManager log.LogPathManager, timestamp int64, logDataPostfix, outputDir string) error {
-	recordHandler, err := perf.NewRecordHandler()
+	recordHandler, err := perf.NewRecordHandler(parser.rawSymbols, parser.lineDetail)
 	if err != nil {
 		return err
 	}
@@ -56,12 +99,35 @@ func (parser *CpuProfileParser) Parse(logPathManager log.LogPathManager, timesta
 	if err := recordHandler.PrepareKernelSymbol(kernSymPath); err != nil {
 		return err
 	}
+	for _, filePath := range matches {
+		if strings.HasSuffix(filePath, ".kern.modules") {
+			if err := recordHandler.PrepareKernelModules(filePath); err != nil {
+				logrus.Errorf("Failed to load kernel modules from [%s], err [%s]", filePath, err)
+			}
+		}
+	}
+
+	for _, filePath := range matches {
+		if strings.HasSuffix(filePath, perf.BuildIDsPostfix) {
+			if err := recordHandler.LoadBuildIDs(filePath, logPathManager.DbgsymPath()); err != nil {
+				logrus.Errorf("Failed to load build IDs from [%s], err [%s]", filePath, err)
+			}
+		}
+	}
+
+	for _, filePath := range matches {
+		if err := parser.loadJitSymbols(filePath, recordHandler); err != nil {
+			logrus.Errorf("Failed to load JIT symbols from [%s], err [%s]", filePath, err)
+		}
+	}
 
 	sort.SliceStable(matches, func(i, j int) bool {
 		return strings.HasSuffix(matches[i], ".synth_events")
 	})
 	for _, filePath := range matches {
-		if strings.HasSuffix(filePath, ".kern.sym") {
+		if symbol.IsKernSymFile(filePath) || strings.HasSuffix(filePath, ".kern.modules") ||
+			strings.HasSuffix(filePath, perf.PerfDataPostfix) || strings.HasSuffix(filePath, perf.BuildIDsPostfix) ||
+			parser.isJitFile(filePath) {
 			continue
 		}
 		if err := parser.parseStackCollapsedData(filePath, recordHandler); err != nil {
@@ -73,5 +139,24 @@ func (parser *CpuProfileParser) Parse(logPathManager log.LogPathManager, timesta
 	if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
 		return err
 	}
-	return recordHandler.GetFlameGraphData().WriteToFile(outputPath)
+	flameGraphData := recordHandler.GetFlameGraphData()
+	if err := flameGraphData.WriteToFile(outputPath); err != nil {
+		return err
+	}
+	if err := flameGraphData.Export(outputPath, parser.flameGraphFormats, "cpu_profile", "none"); err != nil {
+		return err
+	}
+	// the line detail level with inlined frames and source lines
+	if lineFlameGraphData := recordHandler.GetLineFlameGraphData(); lineFlameGraphData != nil {
+		linePath := filepath.Join(filepath.Dir(outputPath), "overall_cpu.line.stack.json")
+		if err := lineFlameGraphData.WriteToFile(linePath); err != nil {
+			return err
+		}
+		if err := lineFlameGraphData.Export(linePath, parser.flameGraphFormats, "cpu_profile", "none"); err != nil {
+			return err
+		}
+	}
+	pprofData := recordHandler.GetPprofData()
+	pprofData.SetTime(timestamp)
+	return pprofData.WriteToFile(filepath.Join(filepath.Dir(outputPath), CpuPprofFile))
 }
@@ -13,7 +13,7 @@ import (
 
 type IoLatParser struct{}
 
-func GetIoLatEbpfParser() (ParserInstance, error) {
+func GetIoLatEbpfParser(_ Options) (ParserInstance, error) {
 	return &IoLatParser{}, nil
 }
 
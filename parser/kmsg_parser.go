@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"hermes/collector"
+	"hermes/log"
+)
+
+const KmsgOverview = "kmsg_overview"
+
+type KmsgRecord struct {
+	Timestamp   int64                 `json:"timestamp"`
+	TriggeredBy string                `json:"triggered_by"`
+	Val         int                   `json:"val"`
+	Triggered   bool                  `json:"triggered"`
+	Matches     []collector.KmsgMatch `json:"matches"`
+}
+
+type KmsgParser struct{}
+
+func GetKmsgParser(_ Options) (ParserInstance, error) {
+	return &KmsgParser{}, nil
+}
+
+func (parser *KmsgParser) getKmsgRecord(timestamp int64, path string) (*KmsgRecord, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var context collector.KmsgContext
+	if err := json.Unmarshal(bytes, &context); err != nil {
+		return nil, err
+	}
+	return &KmsgRecord{
+		Timestamp:   timestamp,
+		TriggeredBy: context.TriggeredBy,
+		Val:         len(context.Matches),
+		Triggered:   context.Triggered,
+		Matches:     context.Matches,
+	}, nil
+}
+
+func (parser *KmsgParser) writeJSONData(rec *KmsgRecord, path string) error {
+	var recs []KmsgRecord
+	if _, err := os.Stat(path); err == nil {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(bytes, &recs); err != nil {
+			return err
+		}
+	}
+
+	recs = append(recs, *rec)
+	bytes, err := json.Marshal(&recs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bytes, 0644)
+}
+
+func (parser *KmsgParser) Parse(logPathManager log.LogPathManager, timestamp int64, logDataPostfix, outputDir string) error {
+	rec, err := parser.getKmsgRecord(timestamp, logPathManager.DataPath(logDataPostfix))
+	if err != nil {
+		return err
+	}
+
+	err = parser.writeJSONData(rec, filepath.Join(outputDir, KmsgOverview))
+	if err != nil {
+		return err
+	}
+	return nil
+}
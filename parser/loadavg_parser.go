@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"hermes/backend/utils"
+	"hermes/collector"
+	"hermes/log"
+)
+
+const LoadAvgOverview = "loadavg_overview"
+
+type LoadAvgRecord struct {
+	Timestamp   int64         `json:"timestamp"`
+	TriggeredBy string        `json:"triggered_by"`
+	Threshold   float64       `json:"threshold"`
+	Val         float64       `json:"val"`
+	Triggered   bool          `json:"triggered"`
+	CpuNum      int           `json:"cpu_num"`
+	LoadAvg     utils.LoadAvg `json:"loadavg"`
+}
+
+type LoadAvgParser struct{}
+
+func GetLoadAvgParser(_ Options) (ParserInstance, error) {
+	return &LoadAvgParser{}, nil
+}
+
+func (parser *LoadAvgParser) getLoadAvgRecord(timestamp int64, path string) (*LoadAvgRecord, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var context collector.LoadAvgContext
+	if err := json.Unmarshal(bytes, &context); err != nil {
+		return nil, err
+	}
+
+	ret := &LoadAvgRecord{
+		Timestamp:   timestamp,
+		TriggeredBy: context.TriggeredBy,
+		Triggered:   context.Triggered,
+		CpuNum:      context.CpuNum,
+	}
+	if context.LoadAvg != nil {
+		ret.LoadAvg = *context.LoadAvg
+	}
+
+	// the threshold and the value of the entry that fired, or of load1
+	entry := context.TriggeredBy
+	if entry == "" {
+		entry = collector.LoadAvgEntries[0]
+	}
+	ret.Threshold = context.Thresholds[entry]
+	ret.Val = ret.LoadAvg.PerCpu(context.CpuNum).GetEntry(entry)
+	return ret, nil
+}
+
+func (parser *LoadAvgParser) writeJSONData(rec *LoadAvgRecord, path string) error {
+	var recs []LoadAvgRecord
+	if _, err := os.Stat(path); err == nil {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(bytes, &recs); err != nil {
+			return err
+		}
+	}
+
+	recs = append(recs, *rec)
+	bytes, err := json.Marshal(&recs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bytes, 0644)
+}
+
+func (parser *LoadAvgParser) Parse(logPathManager log.LogPathManager, timestamp int64, logDataPostfix, outputDir string) error {
+	rec, err := parser.getLoadAvgRecord(timestamp, logPathManager.DataPath(logDataPostfix))
+	if err != nil {
+		return err
+	}
+
+	err = parser.writeJSONData(rec, filepath.Join(outputDir, LoadAvgOverview))
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -13,30 +13,35 @@ import (
 	"hermes/backend/symbol"
 	"hermes/backend/utils"
 	"hermes/log"
+
+	"github.com/google/pprof/profile"
 )
 
 type MemoryEbpfParser struct {
 	dbgDirPath    string
 	symbolizer    symbol.Symbolizer
 	kernelBuildID string
+
+	flameGraphFormats []utils.FlameGraphFormat
 }
 
 const (
 	UnrecordedLabel = "Unrecorded"
 	RecordedLabel   = "Recorded"
-	DbgDir          = ".hermes.memory_alloc_ebpf.dbg"
+	SlabPprofFile   = "slab.pb.gz"
 )
 
-func GetMemoryAllocEbpfParser() (ParserInstance, error) {
-	homeDir, err := os.UserHomeDir()
+func GetMemoryAllocEbpfParser(options Options) (ParserInstance, error) {
+	dbgDirPath, err := symbol.GetDbgDirPath()
 	if err != nil {
 		return nil, err
 	}
-	dbgDirPath := filepath.Join(homeDir, DbgDir)
 
 	return &MemoryEbpfParser{
 		dbgDirPath: dbgDirPath,
-		symbolizer: *symbol.NewSymbolizer(dbgDirPath),
+		symbolizer: *symbol.NewSymbolizer(dbgDirPath, options.RawSymbols),
+
+		flameGraphFormats: options.FlameGraphFormats,
 	}, nil
 }
 
@@ -69,41 +74,54 @@ func (parser *MemoryEbpfParser) getSlabRec(path string) (*map[string]memoryAlloc
 }
 
 func (parser *MemoryEbpfParser) getStacks(slabName string,
-	allocRec *memoryAlloc.AllocRecord, flameGraphData *utils.FlameGraphData) int64 {
+	allocRec *memoryAlloc.AllocRecord, flameGraphData *utils.FlameGraphData, pprofData *utils.PprofData) int64 {
 	var bytesObserved int64 = 0
 
 	for _, allocDetail := range allocRec.AllocDetails {
 		stack := []string{}
+		locations := []*profile.Location{}
 		for _, ip := range allocDetail.CallchainIps {
 			_symbol := fmt.Sprintf("0x%x", ip)
+			frames := []utils.PprofFrame{}
 			if __symbol, err := parser.symbolizer.Symbolize(symbol.KernelMode, parser.kernelBuildID, ip); err == nil {
 				_symbol = __symbol
+				frames = append(frames, utils.PprofFrame{Function: _symbol})
 			}
 			stack = append(stack, _symbol)
+			locations = append(locations, pprofData.AddLocation(nil, ip, frames))
 		}
 		stack = append(stack, allocRec.Comm)
 		stack = append(stack, RecordedLabel)
 		stack = append(stack, slabName)
 		flameGraphData.Add(&stack, len(stack)-1, allocDetail.BytesAlloc)
+		pprofData.AddSample(locations, allocDetail.BytesAlloc, map[string]string{
+			"comm": allocRec.Comm,
+			"slab": slabName,
+		}, nil)
 		bytesObserved = bytesObserved + allocDetail.BytesAlloc
 	}
 	return bytesObserved
 }
 
-func (parser *MemoryEbpfParser) parseStacks(slabName string,
-	bytes int64, rec *memoryAlloc.SlabRecord, flameGraphData *utils.FlameGraphData) {
+func (parser *MemoryEbpfParser) parseStacks(slabName string, bytes int64, rec *memoryAlloc.SlabRecord,
+	flameGraphData *utils.FlameGraphData, pprofData *utils.PprofData) {
 	for _, allocRec := range *rec {
-		bytesObserved := parser.getStacks(slabName, &allocRec, flameGraphData)
+		bytesObserved := parser.getStacks(slabName, &allocRec, flameGraphData, pprofData)
 		bytes = bytes - bytesObserved
 	}
 	if bytes > 0 {
 		stack := []string{UnrecordedLabel, slabName}
 		flameGraphData.Add(&stack, len(stack)-1, bytes)
+		// the bytes without a recorded stack
+		pprofData.AddSample(nil, bytes, map[string]string{
+			"comm": UnrecordedLabel,
+			"slab": slabName,
+		}, nil)
 	}
 }
 
-func (parser *MemoryEbpfParser) writeStackCollapsedData(
-	slabInfo *utils.SlabInfo, slabRec *map[string]memoryAlloc.SlabRecord, path string) error {
+func (parser *MemoryEbpfParser) writeStackCollapsedData(slabInfo *utils.SlabInfo,
+	slabRec *map[string]memoryAlloc.SlabRecord, path string, pprofData *utils.PprofData) error {
 	fp, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		return err
@@ -117,9 +135,12 @@ func (parser *MemoryEbpfParser) writeStackCollapsedData(
 		if !isExist {
 			bytes = 0
 		}
-		parser.parseStacks(slabName, bytes, &rec, flameGraphData)
+		parser.parseStacks(slabName, bytes, &rec, flameGraphData, pprofData)
+	}
+	if err := flameGraphData.WriteToFile(path); err != nil {
+		return err
 	}
-	return flameGraphData.WriteToFile(path)
+	return flameGraphData.Export(path, parser.flameGraphFormats, "memleak_profile", "bytes")
 }
 
 func (parser *MemoryEbpfParser) Parse(logPathManager log.LogPathManager, timestamp int64, logDataPostfix, outputDir string) error {
@@ -145,7 +166,7 @@ func (parser *MemoryEbpfParser) Parse(logPathManager log.LogPathManager, timesta
 	var slabInfo *utils.SlabInfo = nil
 	var slabRec *map[string]memoryAlloc.SlabRecord = nil
 	for _, filePath := range matches {
-		if strings.HasSuffix(filePath, ".kern.sym") {
+		if symbol.IsKernSymFile(filePath) {
 			continue
 		}
 		var err error
@@ -166,5 +187,10 @@ func (parser *MemoryEbpfParser) Parse(logPathManager log.LogPathManager, timesta
 	if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
 		return err
 	}
-	return parser.writeStackCollapsedData(slabInfo, slabRec, outputPath)
+	pprofData := utils.NewPprofData("alloc_space", "bytes")
+	pprofData.SetTime(timestamp)
+	if err := parser.writeStackCollapsedData(slabInfo, slabRec, outputPath, pprofData); err != nil {
+		return err
+	}
+	return pprofData.WriteToFile(filepath.Join(filepath.Dir(outputPath), SlabPprofFile))
 }
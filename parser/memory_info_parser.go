@@ -22,7 +22,7 @@ type MemoryInfoRecord struct {
 
 type MemoryInfoParser struct{}
 
-func GetMemoryInfoParser() (ParserInstance, error) {
+func GetMemoryInfoParser(_ Options) (ParserInstance, error) {
 	return &MemoryInfoParser{}, nil
 }
 
@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	offcpu "hermes/backend/ebpf/offcpu"
+	"hermes/backend/perf"
+	"hermes/backend/symbol"
+	"hermes/backend/utils"
+	"hermes/log"
+
+	"github.com/sirupsen/logrus"
+)
+
+type OffCpuEbpfParser struct {
+	dbgDirPath    string
+	symbolizer    symbol.Symbolizer
+	kernelBuildID string
+	rawSymbols    bool
+
+	flameGraphFormats []utils.FlameGraphFormat
+}
+
+func GetOffCpuEbpfParser(options Options) (ParserInstance, error) {
+	dbgDirPath, err := symbol.GetDbgDirPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return &OffCpuEbpfParser{
+		dbgDirPath: dbgDirPath,
+		symbolizer: *symbol.NewSymbolizer(dbgDirPath, options.RawSymbols),
+		rawSymbols: options.RawSymbols,
+
+		flameGraphFormats: options.FlameGraphFormats,
+	}, nil
+}
+
+func (parser *OffCpuEbpfParser) getOffCpuRecs(path string) ([]offcpu.OffCpuRecord, error) {
+	var recs []offcpu.OffCpuRecord
+
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bytes, &recs); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// loadMaps reads the maps of the processes synthesized by the loader, the
+// user frames are symbolized by them.
+func (parser *OffCpuEbpfParser) loadMaps(filePath string, recordHandler *perf.RecordHandler) error {
+	reader, err := perf.NewRecordReader(filePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := recordHandler.Handle(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// getStack returns the stack from the leaf: the kernel frames where the task
+// blocked, the user frames and the command.
+func (parser *OffCpuEbpfParser) getStack(rec *offcpu.OffCpuRecord, recordHandler *perf.RecordHandler) []string {
+	stack := []string{}
+	for _, ip := range rec.KernCallchain {
+		_symbol := fmt.Sprintf("0x%x", ip)
+		if __symbol, err := parser.symbolizer.Symbolize(symbol.KernelMode, parser.kernelBuildID, ip); err == nil {
+			_symbol = __symbol
+		}
+		stack = append(stack, _symbol)
+	}
+	for _, ip := range rec.UserCallchain {
+		stack = append(stack, recordHandler.Symbolize(rec.Tgid, symbol.UserMode, ip))
+	}
+	stack = append(stack, rec.Comm)
+	return stack
+}
+
+// writeStackCollapsedData writes the flame graph weighted by the off-CPU
+// time in microseconds.
+func (parser *OffCpuEbpfParser) writeStackCollapsedData(recs []offcpu.OffCpuRecord, recordHandler *perf.RecordHandler, path string) error {
+	flameGraphData := utils.NewFlameGraphData()
+	for i := range recs {
+		stack := parser.getStack(&recs[i], recordHandler)
+		flameGraphData.Add(&stack, len(stack)-1, int64(recs[i].OffCpuUs))
+	}
+	if err := flameGraphData.WriteToFile(path); err != nil {
+		return err
+	}
+	return flameGraphData.Export(path, parser.flameGraphFormats, "offcpu_profile", "microseconds")
+}
+
+func (parser *OffCpuEbpfParser) Parse(logPathManager log.LogPathManager, timestamp int64, logDataPostfix, outputDir string) error {
+	matches, err := filepath.Glob(logPathManager.DataPath(logDataPostfix))
+	if err != nil {
+		return err
+	}
+
+	kernSymPath := ""
+	for _, filePath := range matches {
+		if strings.HasSuffix(filePath, ".kern.sym") {
+			kernSymPath = filePath
+			break
+		}
+	}
+
+	buildID, err := symbol.KernelSymPrepare(parser.dbgDirPath, kernSymPath)
+	if err != nil {
+		return err
+	}
+	parser.kernelBuildID = buildID
+
+	recordHandler, err := perf.NewRecordHandler(parser.rawSymbols, false)
+	if err != nil {
+		return err
+	}
+	for _, filePath := range matches {
+		if strings.HasSuffix(filePath, offcpu.BuildIDsFilePostfix) {
+			if err := recordHandler.LoadBuildIDs(filePath, logPathManager.DbgsymPath()); err != nil {
+				logrus.Errorf("Failed to load build IDs from [%s], err [%s]", filePath, err)
+			}
+		}
+	}
+	for _, filePath := range matches {
+		if strings.HasSuffix(filePath, offcpu.SynthEventsFilePostfix) {
+			if err := parser.loadMaps(filePath, recordHandler); err != nil {
+				logrus.Errorf("Failed to load maps from [%s], err [%s]", filePath, err)
+			}
+		}
+	}
+
+	var recs []offcpu.OffCpuRecord
+	for _, filePath := range matches {
+		if symbol.IsKernSymFile(filePath) || strings.HasSuffix(filePath, offcpu.BuildIDsFilePostfix) ||
+			strings.HasSuffix(filePath, offcpu.SynthEventsFilePostfix) {
+			continue
+		}
+		if !strings.HasSuffix(filePath, offcpu.OffCpuRecFilePostfix) {
+			return fmt.Errorf("Unexpected file path [%s]", filePath)
+		}
+		if recs, err = parser.getOffCpuRecs(filePath); err != nil {
+			return err
+		}
+	}
+
+	outputPath := filepath.Join(outputDir, strconv.FormatInt(timestamp, 10), "offcpu.stack.json")
+	if err := os.MkdirAll(filepath.Dir(outputPath), os.ModePerm); err != nil {
+		return err
+	}
+	return parser.writeStackCollapsedData(recs, recordHandler, outputPath)
+}
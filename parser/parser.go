@@ -1,11 +1,15 @@
 package parser
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/sirupsen/logrus"
+	"hermes/backend/utils"
 	"hermes/common"
 	"hermes/log"
 )
@@ -14,9 +18,10 @@ const (
 	CpuProfileJob     = "cpu_profile"
 	MemleakProfileJob = "memleak_profile"
 	IoLatencyJob      = "io_latency"
+	OffCpuProfileJob  = "offcpu_profile"
 )
 
-var ParserGetMapping = map[string]map[common.TaskType]func() (ParserInstance, error){
+var ParserGetMapping = map[string]map[common.TaskType]func(Options) (ParserInstance, error){
 	CpuProfileJob: {
 		common.CpuInfo: GetCpuInfoParser,
 		common.Profile: GetCpuProfileParser,
@@ -29,6 +34,49 @@ var ParserGetMapping = map[string]map[common.TaskType]func() (ParserInstance, er
 		common.PSI:  GetPSIParser,
 		common.Ebpf: GetIoLatEbpfParser,
 	},
+	OffCpuProfileJob: {
+		common.Ebpf: GetOffCpuEbpfParser,
+	},
+}
+
+// CommonParserGetMapping holds the parsers of the tasks that can be used by
+// any job, they write into their own files under the job directory.
+var CommonParserGetMapping = map[common.TaskType]func(Options) (ParserInstance, error){
+	common.LoadAvg: GetLoadAvgParser,
+	common.Kmsg:    GetKmsgParser,
+}
+
+const RunsFile = "runs"
+
+type RunRecord struct {
+	Timestamp int64  `json:"timestamp"`
+	DataLabel string `json:"data_label,omitempty"`
+	Status    string `json:"status"`
+	Coalesced uint32 `json:"coalesced"`
+}
+
+// isSameRun returns whether the records are of the same run, the runs of a
+// second are told apart by their data labels, or by their status for the
+// records written without them.
+func (rec *RunRecord) isSameRun(other *RunRecord) bool {
+	if rec.Timestamp != other.Timestamp {
+		return false
+	}
+	if rec.DataLabel != "" && other.DataLabel != "" {
+		return rec.DataLabel == other.DataLabel
+	}
+	return rec.Status == other.Status
+}
+
+// Options are shared by the task parsers of a parse.
+type Options struct {
+	// RawSymbols keeps the mangled C++ and Rust symbols
+	RawSymbols bool
+	// FlameGraphFormats are exported next to the flame graph JSON
+	FlameGraphFormats []utils.FlameGraphFormat
+	// LineDetail writes the CPU flame graph of the inlined frames and source
+	// lines as well, it reads the DWARF of the binaries
+	LineDetail bool
 }
 
 type ParserInstance interface {
@@ -40,18 +88,32 @@ type Parser struct {
 	outputDir string
 	timestamp int64
 	logMeta   log.LogMetadata
+	options   Options
 }
 
-func NewParser(logDir, outputDir string, timestamp int64, logMeta log.LogMetadata) (*Parser, error) {
+func NewParser(logDir, outputDir string, timestamp int64, logMeta log.LogMetadata, options Options) (*Parser, error) {
 	return &Parser{
 		logDir:    logDir,
 		outputDir: outputDir,
 		timestamp: timestamp,
 		logMeta:   logMeta,
+		options:   options,
 	}, nil
 }
 
+func (parser *Parser) hasTaskParser(jobName string, taskType common.TaskType) bool {
+	if _, isExist := ParserGetMapping[jobName][taskType]; isExist {
+		return true
+	}
+	_, isExist := CommonParserGetMapping[taskType]
+	return isExist
+}
+
 func (parser *Parser) getTaskParser(jobName string, taskType common.TaskType) (ParserInstance, error) {
+	if getParser, isExist := CommonParserGetMapping[taskType]; isExist {
+		return getParser(parser.options)
+	}
+
 	taskMapping, isExist := ParserGetMapping[jobName]
 	if !isExist {
 		return nil, fmt.Errorf("Unhandled job name [%s]", jobName)
@@ -62,12 +124,69 @@ func (parser *Parser) getTaskParser(jobName string, taskType common.TaskType) (P
 		logrus.Printf("parser: %+v", getParser)
 		return nil, fmt.Errorf("Unhandled task type [%d]", taskType)
 	}
-	return getParser()
+	return getParser(parser.options)
+}
+
+func (parser *Parser) writeRunRecord() error {
+	outputDir := filepath.Join(parser.outputDir, parser.logMeta.JobName)
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	var recs []RunRecord
+	path := filepath.Join(outputDir, RunsFile)
+	if _, err := os.Stat(path); err == nil {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(bytes, &recs); err != nil {
+			return err
+		}
+	}
+
+	status := parser.logMeta.Status
+	if status == "" {
+		status = log.JobCompleted
+	}
+	rec := RunRecord{
+		Timestamp: parser.timestamp,
+		DataLabel: parser.logMeta.DataLabel,
+		Status:    status,
+		Coalesced: parser.logMeta.Coalesced,
+	}
+	// a reparsed run replaces its record instead of duplicating it
+	isExist := false
+	for i := range recs {
+		if recs[i].isSameRun(&rec) {
+			recs[i] = rec
+			isExist = true
+		}
+	}
+	if !isExist {
+		recs = append(recs, rec)
+		sort.SliceStable(recs, func(i, j int) bool { return recs[i].Timestamp < recs[j].Timestamp })
+	}
+	bytes, err := json.Marshal(&recs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bytes, 0644)
 }
 
 func (parser *Parser) Parse() error {
+	if _, isExist := ParserGetMapping[parser.logMeta.JobName]; isExist {
+		if err := parser.writeRunRecord(); err != nil {
+			return err
+		}
+	}
+
 	for _, meta := range parser.logMeta.Metadatas {
 		logPathManager := log.NewLogPathManager(parser.logDir).SetDataLabel(parser.logMeta.DataLabel)
+		if !parser.hasTaskParser(parser.logMeta.JobName, common.TaskType(meta.TaskType)) && meta.Triggered != nil {
+			logrus.Warnf("No parser for condition task type [%d] of job [%s], skip it.", meta.TaskType, parser.logMeta.JobName)
+			continue
+		}
 		instance, err := parser.getTaskParser(parser.logMeta.JobName, common.TaskType(meta.TaskType))
 		if err != nil {
 			return err
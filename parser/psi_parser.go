@@ -22,7 +22,7 @@ type PSIRecord struct {
 
 type PSIParser struct{}
 
-func GetPSIParser() (ParserInstance, error) {
+func GetPSIParser(_ Options) (ParserInstance, error) {
 	return &PSIParser{}, nil
 }
 
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"hermes/log"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// HistoryMaxSize is the size of a job history file beyond which it is
+	// rotated, only the previous file is kept.
+	HistoryMaxSize = 1 << 20
+	// HistoryRotatedPostfix is the file of the previous history of a job
+	HistoryRotatedPostfix = ".1"
+	// HistoryLastPostfix is the file of the last executed run of a job, so
+	// that the schedules resume without reading the history.
+	HistoryLastPostfix = ".last"
+)
+
+type FileHistoryStore struct {
+	historyDir string
+	mutex      sync.Mutex
+}
+
+func GetFileHistoryStore(logDir string) (HistoryStore, error) {
+	historyDir := log.NewLogPathManager(logDir).HistoryPath()
+	if err := os.MkdirAll(historyDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &FileHistoryStore{
+		historyDir: historyDir,
+	}, nil
+}
+
+// rotate moves the history file aside once it has grown beyond
+// HistoryMaxSize, it must be called with the mutex held.
+func (store *FileHistoryStore) rotate(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Size() < HistoryMaxSize {
+		return nil
+	}
+	return os.Rename(path, path+HistoryRotatedPostfix)
+}
+
+// writeLast replaces the last executed run of the job, it must be called
+// with the mutex held.
+func (store *FileHistoryStore) writeLast(record *log.RunRecord) error {
+	bytes, err := yaml.Marshal(record)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(store.historyDir, record.JobName+HistoryLastPostfix)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, bytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Append adds the record at the end of the job history file. Each record is
+// marshaled as a single element yaml sequence, so the file as a whole stays a
+// valid sequence without being rewritten.
+func (store *FileHistoryStore) Append(record log.RunRecord) error {
+	bytes, err := yaml.Marshal([]log.RunRecord{record})
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	path := filepath.Join(store.historyDir, record.JobName)
+	if err := store.rotate(path); err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	if _, err = fp.Write(bytes); err != nil {
+		return err
+	}
+	if record.IsExecuted() {
+		return store.writeLast(&record)
+	}
+	return nil
+}
+
+func (store *FileHistoryStore) loadFile(path string) ([]log.RunRecord, error) {
+	var records []log.RunRecord
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(bytes, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Load returns the records of the job, the ones of the rotated file first.
+func (store *FileHistoryStore) Load(jobName string) ([]log.RunRecord, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	path := filepath.Join(store.historyDir, jobName)
+	records, err := store.loadFile(path + HistoryRotatedPostfix)
+	if err != nil {
+		return nil, err
+	}
+	_records, err := store.loadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return append(records, _records...), nil
+}
+
+// Last returns the latest executed record of the job, or nil if it never
+// ran. The histories written before the last run file are scanned.
+func (store *FileHistoryStore) Last(jobName string) (*log.RunRecord, error) {
+	store.mutex.Lock()
+	bytes, err := ioutil.ReadFile(filepath.Join(store.historyDir, jobName+HistoryLastPostfix))
+	store.mutex.Unlock()
+	if err == nil {
+		var record log.RunRecord
+		if err := yaml.Unmarshal(bytes, &record); err != nil {
+			return nil, err
+		}
+		return &record, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	records, err := store.Load(jobName)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].IsExecuted() {
+			return &records[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 
 	"hermes/log"
 
@@ -13,6 +14,8 @@ import (
 
 type FileStorEngine struct {
 	logDir string
+	// the saves of a timestamp read, modify and write the same file
+	mutex sync.Mutex
 }
 
 func GetFileStorEngine(logDir string) (StorEngine, error) {
@@ -37,6 +40,9 @@ func (engine *FileStorEngine) Save(timestamp int64, logMeta log.LogMetadata) err
 	logMetaPath := filepath.Join(log.NewLogPathManager(engine.logDir).MetadataPath(), strconv.FormatInt(timestamp, 10))
 	var metasToWrite []log.LogMetadata
 
+	engine.mutex.Lock()
+	defer engine.mutex.Unlock()
+
 	//collect any existing entries if metadata file already exists
 	if _, err := os.Stat(logMetaPath); !os.IsNotExist(err) {
 		metasToWrite, err = engine.loadFile(logMetaPath)
@@ -53,7 +59,7 @@ func (engine *FileStorEngine) Save(timestamp int64, logMeta log.LogMetadata) err
 		return err
 	}
 
-	fp, err := os.OpenFile(logMetaPath, os.O_WRONLY|os.O_CREATE, 0644)
+	fp, err := os.OpenFile(logMetaPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		return err
 	}
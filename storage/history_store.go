@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"fmt"
+
+	"hermes/log"
+)
+
+var (
+	historyStoreMap = map[string]func(string) (HistoryStore, error){
+		"file": GetFileHistoryStore,
+	}
+)
+
+type HistoryStore interface {
+	Append(record log.RunRecord) error
+	Load(jobName string) ([]log.RunRecord, error)
+	Last(jobName string) (*log.RunRecord, error)
+}
+
+func GetHistoryStore(storEngine, logDir string) (HistoryStore, error) {
+	getHistoryStoreFunc, isExist := historyStoreMap[storEngine]
+	if isExist {
+		return getHistoryStoreFunc(logDir)
+	}
+	return nil, fmt.Errorf("Unhandled history store [%s]", storEngine)
+}